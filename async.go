@@ -0,0 +1,91 @@
+package nullitics
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by Hit in the asynchronous mode if the hit is
+// dropped because the queue is full.
+var ErrQueueFull = errors.New("hit queue is full")
+
+// ErrClosed is returned by Hit in the asynchronous mode if the collector is
+// closed, since there is no one to write the hit to the log anymore.
+var ErrClosed = errors.New("collector is closed")
+
+// Async enables the asynchronous mode, in which Hit only puts the hit into a
+// queue of the given size and returns immediately. Queued hits are written to
+// the log in batches by a background goroutine every interval, or as soon as a
+// batch of the queue size is collected, so the log rollover never blocks the
+// callers. Hits are dropped if the queue is full, and the queued hits are lost
+// if the collector is not closed. Non-positive size means the synchronous
+// mode, and non-positive interval means one second.
+func Async(size int, interval time.Duration) Option {
+	return func(c *Collector) {
+		if size <= 0 {
+			c.queue = nil
+			return
+		}
+		if interval <= 0 {
+			interval = time.Second
+		}
+		c.queue = make(chan *Hit, size)
+		c.interval = interval
+	}
+}
+
+// enqueue puts the hit into the queue without blocking.
+func (c *Collector) enqueue(hit *Hit) error {
+	// Close waits for the pending enqueues, so no hit is queued after the
+	// queue is drained
+	c.closing.RLock()
+	defer c.closing.RUnlock()
+	select {
+	case <-c.stop:
+		return ErrClosed
+	default:
+	}
+	select {
+	case c.queue <- hit:
+		return nil
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		return ErrQueueFull
+	}
+}
+
+// run writes the queued hits in batches until the collector is closed.
+func (c *Collector) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	batch := make([]*Hit, 0, cap(c.queue))
+	flush := func() {
+		if len(batch) > 0 {
+			// Errors are counted in metrics
+			_ = c.record(batch)
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case hit := <-c.queue:
+			if batch = append(batch, hit); len(batch) >= cap(c.queue) {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-c.stop:
+			for {
+				select {
+				case hit := <-c.queue:
+					batch = append(batch, hit)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
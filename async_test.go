@@ -0,0 +1,113 @@
+package nullitics
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsync(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(dir), Location(time.UTC), Clock(clock.Now), Async(100, time.Hour))
+	for i := 0; i < 25; i++ {
+		if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		// Hits of the next day roll the log over in the middle of the batch
+		if i == 20 {
+			clock.Add(24 * time.Hour)
+		}
+	}
+	// Queued hits are written on close
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c = New(Dir(dir), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	daily, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := daily.URIs.Row("/").Last(24); n != 4 {
+		t.Error(daily.URIs.Rows)
+	}
+	if n := history.URIs.Row("/").Values[0]; n != 21 {
+		t.Error(history.URIs.Rows)
+	}
+}
+
+func TestAsyncDropped(t *testing.T) {
+	c := New(Dir(t.TempDir()), Async(2, time.Millisecond))
+	// Writer is blocked while the collector is locked, so the queue overflows
+	c.Lock()
+	dropped := 0
+	for i := 0; i < 100; i++ {
+		if err := c.Hit(&Hit{Timestamp: time.Now(), URI: "/", Session: "a"}); err == ErrQueueFull {
+			dropped++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Unlock()
+	if dropped < 96 || c.dropped != uint64(dropped) {
+		t.Error(dropped, c.dropped)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readFile(t, c.dir+"/"+dailyLog); strings.Count(s, "\n") != 100-dropped {
+		t.Error(s)
+	}
+}
+
+func TestAsyncClosed(t *testing.T) {
+	c := New(Dir(t.TempDir()), Async(10, time.Hour))
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Hit(&Hit{Timestamp: time.Now(), URI: "/", Session: "a"}); err != ErrClosed {
+		t.Error(err)
+	}
+}
+
+func TestAsyncSize(t *testing.T) {
+	// Non-positive size means the synchronous mode
+	for _, size := range []int{0, -1} {
+		dir := t.TempDir()
+		c := New(Dir(dir), Async(size, time.Hour))
+		if c.queue != nil {
+			t.Error(size, cap(c.queue))
+		}
+		if err := c.Hit(&Hit{Timestamp: time.Now(), URI: "/", Session: "a"}); err != nil {
+			t.Error(err)
+		}
+		if s := readFile(t, dir+"/"+dailyLog); strings.Count(s, "\n") != 1 {
+			t.Error(size, s)
+		}
+		c.Close()
+	}
+}
+
+// Queued hits are written on close, so it's included in the timing. With few
+// CPUs the writer goroutine competes with the callers and some hits are
+// dropped, which is reported as dropped/op.
+func benchmarkHit(b *testing.B, options ...Option) {
+	c := New(append(options, Dir(b.TempDir()))...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = c.Hit(&Hit{Timestamp: time.Now(), URI: "/", Session: "a", Ref: "example.com", Country: "DE", Device: Desktop})
+		}
+	})
+	if err := c.Close(); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadUint64(&c.dropped))/float64(b.N), "dropped/op")
+}
+
+func BenchmarkHit(b *testing.B)      { benchmarkHit(b) }
+func BenchmarkHitAsync(b *testing.B) { benchmarkHit(b, Async(1<<16, 10*time.Millisecond)) }
@@ -0,0 +1,162 @@
+package nullitics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// backupFiles returns the names of the collector data files stored in
+// backups, including the log shards. The salt is not included, so the sessions
+// can not be linked to the visitors using a backup.
+func (c *Collector) backupFiles() ([]string, error) {
+	shards, err := filepath.Glob(filepath.Join(c.dir, "log.*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{historyLog, dailyLog}
+	for _, shard := range shards {
+		names = append(names, filepath.Base(shard))
+	}
+	return names, nil
+}
+
+// Backup writes a gzipped tar archive with the collector data. The archive is
+// taken under the collector lock, so it is consistent even if the hits are
+// being recorded. In the shared storage mode the rollover is locked, too.
+func (c *Collector) Backup(w io.Writer) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.shard != "" {
+		unlock, err := c.lockShared()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	names, err := c.backupFiles()
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0666, Size: int64(len(b)), ModTime: c.now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		} else if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Restore replaces the collector data with the one from the archive created by
+// Backup. The archive is checked before any file is replaced. Stats and the
+// own log missing in the archive are removed, log shards of other processes
+// are kept, so they should be stopped during the restore.
+func (c *Collector) Restore(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if !isBackupFile(hdr.Name) {
+			return errors.New("unexpected file in backup: " + hdr.Name)
+		}
+		if files[hdr.Name], err = ioutil.ReadAll(tr); err != nil {
+			return err
+		}
+	}
+	if b, ok := files[historyLog]; ok {
+		if _, err := ParseStatsCSV(string(b)); err != nil {
+			return fmt.Errorf("invalid %s in backup: %w", historyLog, err)
+		}
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.shard != "" {
+		unlock, err := c.lockShared()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	if err := c.closeAppender(); err != nil {
+		return err
+	}
+	_ = os.MkdirAll(c.dir, 0777)
+	// All files are written to temporary names first, so that a failed write
+	// leaves the current data in place
+	for name, b := range files {
+		tmp := filepath.Join(c.dir, name+".tmp")
+		if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+			for name := range files {
+				_ = os.Remove(filepath.Join(c.dir, name+".tmp"))
+			}
+			return err
+		}
+	}
+	for name := range files {
+		filename := filepath.Join(c.dir, name)
+		if err := os.Rename(filename+".tmp", filename); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{historyLog, c.logFile()} {
+		if _, ok := files[name]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// Stats are re-read from the restored files
+	c.history = nil
+	c.recent = recentHits{}
+	return nil
+}
+
+func isBackupFile(name string) bool {
+	return name == historyLog || isLogFile(name)
+}
+
+// BackupHandler returns an HTTP handler that downloads the collector backup.
+// It exposes all the collected data, so it should be put behind
+// authentication.
+func (c *Collector) BackupHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := &bytes.Buffer{}
+		if err := c.Backup(b); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="nullitics-`+c.now().Format(dateFormat)+`.tar.gz"`)
+		_, _ = w.Write(b.Bytes())
+	})
+}
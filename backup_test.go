@@ -0,0 +1,176 @@
+package nullitics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackupRestore(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(24 * time.Hour)
+	}
+	b := &bytes.Buffer{}
+	if err := c.Backup(b); err != nil {
+		t.Fatal(err)
+	}
+	// Hits after the backup are lost on restore
+	if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/new", Session: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	r := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now))
+	defer r.Close()
+	if err := r.Restore(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restore(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Collector{c, r} {
+		daily, history, err := c.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(history.URIs.Rows, []Row{{Name: "/", Values: []int{1, 1, 1}}}) {
+			t.Error(history.URIs.Rows)
+		}
+		if n := daily.URIs.Row("/").Last(24); n != 1 {
+			t.Error(daily.URIs.Rows)
+		}
+	}
+	// Collector keeps recording after restore
+	if err := r.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, history, _ := r.Stats(); !reflect.DeepEqual(history.URIs.Row("/").Values, []int{1, 1, 1, 1}) {
+		t.Error(history.URIs.Rows)
+	}
+}
+
+func TestRestoreUnexpectedFile(t *testing.T) {
+	b := &bytes.Buffer{}
+	gz := gzip.NewWriter(b)
+	tw := tar.NewWriter(gz)
+	_ = tw.WriteHeader(&tar.Header{Name: "../salt.txt", Mode: 0666})
+	tw.Close()
+	gz.Close()
+	c := New(Dir(t.TempDir()))
+	defer c.Close()
+	if err := c.Restore(b); err == nil {
+		t.Error("should fail")
+	}
+}
+
+func TestRestoreInvalidStats(t *testing.T) {
+	b := &bytes.Buffer{}
+	gz := gzip.NewWriter(b)
+	tw := tar.NewWriter(gz)
+	for name, data := range map[string]string{dailyLog: "1609495200,/a,a,,,desktop\n", historyLog: "#2021-01-01T00:00:00Z,24h0m0s\n/,x\n"} {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0666, Size: int64(len(data))})
+		_, _ = tw.Write([]byte(data))
+	}
+	tw.Close()
+	gz.Close()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, dailyLog), []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	c := New(Dir(dir))
+	defer c.Close()
+	if err := c.Restore(b); err == nil {
+		t.Error("should fail")
+	}
+	// No file is replaced
+	if s := readFile(t, filepath.Join(dir, dailyLog)); s != "old" {
+		t.Error(s)
+	}
+}
+
+func TestRestoreWriteError(t *testing.T) {
+	b := &bytes.Buffer{}
+	gz := gzip.NewWriter(b)
+	tw := tar.NewWriter(gz)
+	data := "1609495200,/a,a,,,desktop\n"
+	_ = tw.WriteHeader(&tar.Header{Name: dailyLog, Mode: 0666, Size: int64(len(data))})
+	_, _ = tw.Write([]byte(data))
+	tw.Close()
+	gz.Close()
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, dailyLog), []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, historyLog), []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// Temporary file can not be written over a directory
+	if err := os.Mkdir(filepath.Join(dir, dailyLog+".tmp"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	c := New(Dir(dir))
+	defer c.Close()
+	if err := c.Restore(b); err == nil {
+		t.Error("should fail")
+	}
+	// Neither the replaced nor the removed files are lost
+	for _, name := range []string{dailyLog, historyLog} {
+		if s := readFile(t, filepath.Join(dir, name)); s != "old" {
+			t.Error(name, s)
+		}
+	}
+}
+
+func TestRestoreKeepsOtherShards(t *testing.T) {
+	dir := t.TempDir()
+	a := New(Dir(dir), Shard("a"), Location(time.UTC))
+	defer a.Close()
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	if err := a.Hit(&Hit{Timestamp: ts, URI: "/", Session: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if err := a.Backup(b); err != nil {
+		t.Fatal(err)
+	}
+	// Shard of another process written after the backup is kept
+	other := New(Dir(dir), Shard("b"), Location(time.UTC))
+	defer other.Close()
+	if err := other.Hit(&Hit{Timestamp: ts, URI: "/b", Session: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Restore(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log.b.csv")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackupHandler(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	defer c.Close()
+	if err := c.Hit(&Hit{Timestamp: time.Now(), URI: "/", Session: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.BackupHandler().ServeHTTP(w, httptest.NewRequest("GET", "/backup", nil))
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := tar.NewReader(gz).Next()
+	if err != nil || hdr.Name != dailyLog {
+		t.Error(hdr, err)
+	}
+}
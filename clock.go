@@ -0,0 +1,37 @@
+package nullitics
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a manually controlled time source. It is safe for concurrent
+// use and is meant to be passed into the Clock option in tests.
+type FakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewFakeClock returns a fake clock stopped at the given time.
+func NewFakeClock(t time.Time) *FakeClock { return &FakeClock{t: t} }
+
+// Now returns the current fake time.
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.t
+}
+
+// Set moves the fake clock to the given time.
+func (fc *FakeClock) Set(t time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.t = t
+}
+
+// Add moves the fake clock forward by the given duration.
+func (fc *FakeClock) Add(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.t = fc.t.Add(d)
+}
@@ -1,26 +1,193 @@
 // This is basically "tracking-pixel-as-a-service".
 // It serves a blank 1x1px GIF and records how many times it has been called.
 // You may use it with a static web site or from other web services.
+//
+// The data directory can be backed up and restored with "pixel -dir data
+// backup file.tar.gz" and "pixel -dir data restore file.tar.gz", the file
+// defaults to stdout or stdin. Restore should be done while the service is
+// stopped.
+//
+// Stats of several data directories, i.e. from multiple replicas, are combined
+// into a new empty one with "pixel -dir merged merge dir1 dir2...". The running
+// service can also report the combined stats of other directories with the
+// -replicas flag.
 
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nullitics/nullitics"
 )
 
+// listFlag is a flag that can be repeated multiple times
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error { *l = append(*l, s); return nil }
+
+// parseLimits parses frame limits in the form of "URIs=1000,10000,5", missing
+// values are zero.
+func parseLimits(s string) (string, nullitics.Limits, error) {
+	l := nullitics.Limits{}
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return "", l, errors.New("invalid limit: " + s)
+	}
+	values := []*int{&l.Daily, &l.History, &l.Prune}
+	for i, v := range strings.Split(parts[1], ",") {
+		if i >= len(values) {
+			return "", l, errors.New("invalid limit: " + s)
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return "", l, err
+		}
+		*values[i] = n
+	}
+	return parts[0], l, nil
+}
+
+// basicAuth allows requests with the given "user:password" credentials only.
+func basicAuth(h http.Handler, credentials string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user+":"+password), []byte(credentials)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="nullitics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// backup writes the collector backup to the file, or to stdout if the
+// filename is empty.
+func backup(c *nullitics.Collector, filename string) error {
+	if filename == "" {
+		return c.Backup(os.Stdout)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := c.Backup(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// restore reads the collector backup from the file, or from stdin if the
+// filename is empty.
+func restore(c *nullitics.Collector, filename string) error {
+	var r io.Reader = os.Stdin
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return c.Restore(r)
+}
+
+// merge writes the history combined from the source data directories into the
+// stats file of the target directory. The target must not have any data and
+// must not be one of the sources, so that the merge is never counted twice.
+func merge(options []nullitics.Option, dir string, sources []string) error {
+	if dir == "" || len(sources) == 0 {
+		return errors.New("usage: pixel -dir target merge dir1 dir2...")
+	}
+	for _, src := range sources {
+		if same, err := sameDir(dir, src); err != nil {
+			return err
+		} else if same {
+			return errors.New("merge target is one of the sources: " + src)
+		}
+	}
+	if files, err := filepath.Glob(filepath.Join(dir, "*.csv")); err != nil {
+		return err
+	} else if len(files) > 0 {
+		return errors.New("merge target already has data: " + dir)
+	}
+	history := &nullitics.Stats{}
+	for _, src := range sources {
+		_, h, err := nullitics.New(append(options, nullitics.Dir(src))...).Stats()
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		} else if err := history.Merge(h); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "stats.csv"), []byte(history.CSV()), 0666)
+}
+
+// sameDir returns true if both paths point to the same existing directory.
+func sameDir(a, b string) (bool, error) {
+	fa, err := os.Stat(a)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fa, fb), nil
+}
+
 func main() {
 	port := flag.String("port", "8080", "Port number")
 	url := flag.String("url", "http://localhost:8080", "External address of this service")
 	dir := flag.String("dir", "", "Directory to store stats")
 	loc := flag.String("loc", "Local", "Time zone")
-	salt := flag.String("salt", nullitics.RandomString(32), "Salt for hashes")
+	salt := flag.String("salt", "", "Salt for hashes (default: random, or none with -shard)")
+	proxies := flag.String("proxies", "127.0.0.0/8,::1/128", "Comma-separated CIDRs of trusted reverse proxies")
+	datacenters := flag.String("datacenters", "", "File with datacenter IP ranges (CIDR per line) to treat as bots")
+	noUA := flag.Bool("bots-no-ua", true, "Treat requests without User-Agent header as bots")
+	noLang := flag.Bool("bots-no-lang", true, "Treat requests without Accept-Language header as bots")
+	refs := flag.String("refs", "", "File with custom referrer rules")
+	hosts := flag.String("hosts", "", "Comma-separated site host names to ignore in referrers (default: page host)")
+	goals := listFlag{}
+	flag.Var(&goals, "goal", "Conversion goal as name=/path/pattern or name=event:name, can be repeated")
+	funnels := listFlag{}
+	flag.Var(&funnels, "funnel", "Funnel as name=/step1,/step2,event:name, can be repeated")
+	paths := listFlag{}
+	flag.Var(&paths, "path", "Path rewrite rule as /users/:id or ^regexp$=replacement (required for regexps), can be repeated")
+	lowercase := flag.Bool("lowercase", false, "Record page paths in lower case")
+	trimSlash := flag.Bool("trim-slash", false, "Remove trailing slashes from page paths")
+	query := flag.String("query", "", "Comma-separated query parameters to keep in page paths")
+	limits := listFlag{}
+	flag.Var(&limits, "limit", "Frame cardinality limits as URIs=daily,history,prune, can be repeated")
+	retainDays := flag.Int("retain-days", 0, "Days of history to keep with daily resolution (default: forever)")
+	retainPeriod := flag.String("retain-period", nullitics.Monthly, "Resolution of the older history: week or month")
+	maxAge := flag.Int("max-age", 0, "Days after which history is deleted (default: never)")
+	shard := flag.String("shard", "", "Unique name of this process to share the data directory with other processes, i.e. host name")
+	replicas := flag.String("replicas", "", "Comma-separated data directories of other replicas to include in the report")
+	queue := flag.Int("queue", 0, "Size of the queue to record hits asynchronously (default: synchronous)")
+	flush := flag.Duration("flush", time.Second, "Interval to write the queued hits")
+	admin := flag.String("admin", "", "Credentials as user:password to access backups at /backup and metrics at /metrics (default: disabled)")
 	flag.Parse()
 
 	location, err := time.LoadLocation(*loc)
@@ -28,10 +195,142 @@ func main() {
 		log.Fatal(err)
 	}
 
-	c := nullitics.New(nullitics.Dir(*dir),
+	trusted := []*net.IPNet{}
+	for _, cidr := range strings.Split(*proxies, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	options := []nullitics.Option{
+		nullitics.Dir(*dir),
 		nullitics.Location(location),
-		nullitics.Salt(*salt))
+		nullitics.TrustedProxies(trusted...),
+	}
+	if *salt != "" {
+		options = append(options, nullitics.Salt(*salt))
+	}
+	if !*noUA || !*noLang {
+		options = append(options, nullitics.BotHeuristics(*noUA, *noLang))
+	}
+	if *datacenters != "" {
+		dc, err := nullitics.NewIPList(*datacenters)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, nullitics.Datacenters(dc))
+	}
+	if *hosts != "" {
+		options = append(options, nullitics.Hosts(strings.Split(*hosts, ",")...))
+	}
+	if len(goals) > 0 {
+		list := []nullitics.Goal{}
+		for _, s := range goals {
+			goal, ok := nullitics.ParseGoal(s)
+			if !ok {
+				log.Fatal("invalid goal: " + s)
+			}
+			list = append(list, goal)
+		}
+		options = append(options, nullitics.Goals(list...))
+	}
+	if len(funnels) > 0 {
+		list := []nullitics.Funnel{}
+		for _, s := range funnels {
+			funnel, ok := nullitics.ParseFunnel(s)
+			if !ok {
+				log.Fatal("invalid funnel: " + s)
+			}
+			list = append(list, funnel)
+		}
+		options = append(options, nullitics.Funnels(list...))
+	}
+	for _, s := range paths {
+		parts := strings.SplitN(s, "=", 2)
+		replace := ""
+		if len(parts) == 2 {
+			replace = parts[1]
+		}
+		rule, err := nullitics.NewPathRule(parts[0], replace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, nullitics.Paths(rule))
+	}
+	if *lowercase {
+		options = append(options, nullitics.LowerCasePaths())
+	}
+	if *trimSlash {
+		options = append(options, nullitics.TrimTrailingSlash())
+	}
+	if *query != "" {
+		options = append(options, nullitics.KeepQuery(strings.Split(*query, ",")...))
+	}
+	for _, s := range limits {
+		frame, l, err := parseLimits(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, nullitics.Limit(frame, l))
+	}
+	if *retainDays > 0 || *maxAge > 0 {
+		options = append(options, nullitics.Retain(nullitics.Retention{Days: *retainDays, Period: *retainPeriod, MaxAge: *maxAge}))
+	}
+	if *refs != "" {
+		rules, err := nullitics.LoadRefRules(*refs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, nullitics.Referrers(rules...))
+	}
+
+	if flag.Arg(0) == "merge" {
+		if err := merge(options, *dir, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *queue > 0 {
+		options = append(options, nullitics.Async(*queue, *flush))
+	}
+	if *shard != "" {
+		options = append(options, nullitics.Shard(*shard))
+	}
+	if *replicas != "" {
+		options = append(options, nullitics.Replicas(strings.Split(*replicas, ",")...))
+	}
+
+	c := nullitics.New(options...)
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case "backup", "restore":
+		run := backup
+		if cmd == "restore" {
+			run = restore
+		}
+		if err := run(c, flag.Arg(1)); err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatal("unknown command: " + cmd)
+	}
 	report := c.Report(nil)
+	script := c.Script()
+	var metrics, backups http.Handler
+	if *admin != "" {
+		metrics = basicAuth(c.Metrics(), *admin)
+		backups = basicAuth(c.BackupHandler(), *admin)
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		log.Println(r.Method, r.URL.Path, r.UserAgent(), r.Referer())
@@ -39,16 +338,32 @@ func main() {
 		case r.URL.Path == "/":
 			// Show statistics report
 			report.ServeHTTP(w, r)
+		case r.URL.Path == "/metrics" && metrics != nil:
+			// Expose Prometheus metrics
+			metrics.ServeHTTP(w, r)
+		case r.URL.Path == "/backup" && backups != nil:
+			// Download a backup of the collected data
+			backups.ServeHTTP(w, r)
 		case strings.HasSuffix(r.URL.Path, ".js"):
-			// Return a JS snippet
-			w.Header().Add("Content-Type", "application/javascript")
-			fmt.Fprintf(w, `new Image().src='`+*url+`/null.gif?u='+encodeURI(location.href)+'&r='+encodeURI(document.referrer)+'&d='+screen.width`)
+			// Return the tracking script
+			script.ServeHTTP(w, r)
 		case strings.HasSuffix(r.URL.Path, ".gif"):
 			// Serve a tracking pixel and record a hit
 			c.ServeHTTP(w, r)
 		}
 	})
 
+	// Queued hits are written on shutdown
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+		os.Exit(0)
+	}()
+
 	log.Println("Started on port " + *port + ", check " + *url)
 	log.Fatal(http.ListenAndServe(":"+*port, nil))
 }
@@ -3,24 +3,29 @@ package nullitics
 import (
 	"embed"
 	_ "embed" // embed package must be imported for embedded FS to work
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
+	"log"
 	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
-// Now is a timestamp generator. It is made public so that the caller could
-// mock it or replace with their own implementation.
-var Now = time.Now
-
 var dailyLog = "log.csv"
 var historyLog = "stats.csv"
+var saltFile = "salt.txt"
 
 var (
 	// MaxPathLength is the longest possible URI or event name length.
@@ -35,13 +40,42 @@ var (
 
 // Collector is an abstracton that records Hits and provides collected Stats.
 type Collector struct {
+	// dropped is the number of hits dropped in the asynchronous mode, it's
+	// the first field to be 64-bit aligned for atomic operations
+	dropped uint64
 	sync.Mutex
-	dir       string
-	location  *time.Location
-	blacklist func(string) bool
-	salt      string
-	appender  *Appender
-	history   *Stats
+	dir         string
+	location    *time.Location
+	now         func() time.Time
+	blacklist   func(string) bool
+	geo         GeoFinder
+	proxies     []*net.IPNet
+	datacenters IPChecker
+	allowNoUA   bool
+	allowNoLang bool
+	refs        RefRules
+	hosts       []string
+	goals       []Goal
+	funnels     []Funnel
+	paths       paths
+	limits      map[string]Limits
+	retention   Retention
+	replicas    []string
+	replicaData map[string]*replicaStats
+	shard       string
+	queue       chan *Hit
+	interval    time.Duration
+	stop        chan struct{}
+	done        chan struct{}
+	stopOnce    sync.Once
+	closing     sync.RWMutex
+	recent      recentHits
+	metrics     metrics
+	salt        string
+	saltSet     bool
+	salts       *dailySalt
+	appender    *Appender
+	history     *Stats
 }
 
 // Option is a function option data type for Collector.
@@ -68,15 +102,141 @@ func Dir(dir string) Option { return func(c *Collector) { c.dir = dir } }
 // Location set the collector time zone.
 func Location(loc *time.Location) Option { return func(c *Collector) { c.location = loc } }
 
-// Salt initializes the collector salt for hashes. By default the salt is a random string.
-func Salt(salt string) Option { return func(c *Collector) { c.salt = salt } }
+// Clock sets the collector time source, used for hit timestamps and daily
+// session hashes. By default it is time.Now.
+func Clock(now func() time.Time) Option { return func(c *Collector) { c.now = now } }
+
+// Geo sets the GeoFinder used to detect visitor countries. By default the
+// global GeoDB is used.
+func Geo(geo GeoFinder) Option { return func(c *Collector) { c.geo = geo } }
+
+// Datacenters sets the IPChecker for the known datacenter and hosting IP
+// ranges. Hits from such addresses are considered to be bots.
+func Datacenters(dc IPChecker) Option { return func(c *Collector) { c.datacenters = dc } }
+
+// BotHeuristics sets whether requests without the User-Agent or without the
+// Accept-Language header are considered to be bots. Both checks are enabled by
+// default, but may need to be disabled for hits sent by privacy-focused
+// browsers or by server-side clients.
+func BotHeuristics(noUserAgent, noAcceptLanguage bool) Option {
+	return func(c *Collector) { c.allowNoUA, c.allowNoLang = !noUserAgent, !noAcceptLanguage }
+}
+
+// Goals sets the conversion goals. Daily stats would contain the number of
+// converted sessions for each goal, broken down by referrer, country and
+// device. Goals with names containing commas, line breaks or "|" are ignored.
+func Goals(goals ...Goal) Option {
+	return func(c *Collector) {
+		c.goals = nil
+		for _, g := range goals {
+			if validName(g.Name) {
+				c.goals = append(c.goals, g)
+			}
+		}
+	}
+}
+
+// Funnels sets the conversion funnels. Daily stats would contain the number of
+// sessions that reached each funnel step. Funnels with names or step names
+// containing commas, line breaks or "|" are ignored.
+func Funnels(funnels ...Funnel) Option {
+	return func(c *Collector) {
+		c.funnels = nil
+		for _, f := range funnels {
+			if f.valid() {
+				c.funnels = append(c.funnels, f)
+			}
+		}
+	}
+}
+
+// Paths adds path rewrite rules. The first matching rule is applied to the page
+// path, after it has been lower-cased and trimmed if requested.
+func Paths(rules ...PathRule) Option {
+	return func(c *Collector) { c.paths.rules = append(c.paths.rules, rules...) }
+}
+
+// LowerCasePaths records page paths in lower case.
+func LowerCasePaths() Option { return func(c *Collector) { c.paths.lowercase = true } }
+
+// TrimTrailingSlash removes trailing slashes from page paths, except for the
+// root path.
+func TrimTrailingSlash() Option { return func(c *Collector) { c.paths.trim = true } }
+
+// KeepQuery sets the query parameters that are kept in the page paths. By
+// default all query parameters are dropped. TrackingParams are always dropped.
+func KeepQuery(params ...string) Option { return func(c *Collector) { c.paths.query = params } }
+
+// Limit sets the cardinality limits for the stats frame with the given name,
+// i.e. "URIs" or "Refs". By default DefaultLimits are used.
+func Limit(frame string, limits Limits) Option {
+	return func(c *Collector) {
+		if c.limits == nil {
+			c.limits = map[string]Limits{}
+		}
+		c.limits[frame] = limits
+	}
+}
+
+// Retain sets the data retention policy for the historical stats. It is applied
+// at the end of each day. By default all data is kept with daily resolution.
+func Retain(r Retention) Option { return func(c *Collector) { c.retention = r } }
+
+// Replicas adds the data directories of other collectors, i.e. running in
+// other replicas of the service, which stats are merged into the reported
+// ones.
+func Replicas(dirs ...string) Option {
+	return func(c *Collector) { c.replicas = append(c.replicas, dirs...) }
+}
+
+// Hosts sets the site's own host names. Referrers from these hosts are
+// considered to be internal navigation and are not recorded. By default the
+// host of the visited page is used.
+func Hosts(hosts ...string) Option { return func(c *Collector) { c.hosts = hosts } }
+
+// Referrers adds custom referrer rules. They take precedence over the previously
+// added ones and the DefaultRefRules.
+func Referrers(rules ...RefRule) Option {
+	return func(c *Collector) { c.refs = append(append(RefRules{}, rules...), c.refs...) }
+}
+
+// TrustedProxies sets the networks of reverse proxies in front of the
+// collector. Forwarded, X-Real-IP and X-Forwarded-For headers are only used
+// to detect visitor IP address if the request comes from one of these
+// networks. By default no proxies are trusted.
+func TrustedProxies(networks ...*net.IPNet) Option {
+	return func(c *Collector) { c.proxies = networks }
+}
+
+// Salt initializes the collector secret that is mixed into session hashes on
+// top of the daily rotating salt. By default the salt is a random string, or
+// none in the shared storage mode.
+func Salt(salt string) Option { return func(c *Collector) { c.salt, c.saltSet = salt, true } }
 
 // New creates a collector instance with the given options.
 func New(options ...Option) *Collector {
-	c := &Collector{salt: RandomString(32), location: time.Local}
+	c := &Collector{salt: RandomString(32), location: time.Local, now: time.Now, geo: GeoDB, refs: DefaultRefRules}
 	for _, opt := range options {
 		opt(c)
 	}
+	c.salts = &dailySalt{}
+	if c.dir != "" {
+		c.salts.filename = filepath.Join(c.dir, saltFile)
+	}
+	if c.shard != "" {
+		c.salts.lock = c.lockShared
+		// Random salts would differ between the processes, so the sessions
+		// are keyed with the shared daily salt only
+		if !c.saltSet {
+			c.salt = ""
+		}
+	}
+	// Salt of the past days may be left on disk if the collector was stopped
+	c.salts.expire(c.now().In(c.location))
+	if c.queue != nil {
+		c.stop, c.done = make(chan struct{}), make(chan struct{})
+		go c.run()
+	}
 	return c
 }
 
@@ -85,34 +245,86 @@ func date(t time.Time) time.Time {
 	return time.Date(yyyy, mm, dd, 0, 0, 0, 0, t.Location())
 }
 
-// Hit records a single hit data.
+// Hit records a single hit data. In the asynchronous mode the hit is only
+// queued, and ErrQueueFull is returned if it's dropped.
 func (c *Collector) Hit(hit *Hit) error {
+	if hit.err != nil {
+		c.Lock()
+		c.metrics.errors++
+		c.Unlock()
+		return hit.err
+	}
 	if c.blacklist != nil && c.blacklist(hit.URI) {
 		return nil
 	}
+	if c.queue != nil {
+		return c.enqueue(hit)
+	}
+	return c.record([]*Hit{hit})
+}
+
+// record writes the hits to the log at once, rolling the log over into the
+// history when the day changes.
+func (c *Collector) record(hits []*Hit) (err error) {
 	c.Lock()
 	defer c.Unlock()
-	if err := c.checkAppender(false); err != nil {
-		return err
-	}
-	startTime := c.appender.StartTime().In(c.location)
-	if date(hit.Timestamp.In(c.location)) != date(startTime) && !startTime.IsZero() {
-		if err := c.closeAppender(); err != nil {
-			return err
-		} else if err := c.checkHistoricalStats(); err != nil {
-			return err
-		} else if stats, err := c.readDailyStats(); err != nil {
+	written := 0
+	defer func() {
+		if err != nil {
+			c.metrics.errors += uint64(len(hits) - written)
+		}
+	}()
+	for i, hit := range hits {
+		if err := c.checkAppender(false); err != nil {
 			return err
-		} else {
-			c.mergeAppender(stats)
-			if err := c.saveHistoricalStats(); err != nil {
+		}
+		startTime := c.appender.StartTime().In(c.location)
+		today := date(hit.Timestamp.In(c.location))
+		if today != date(startTime) && !startTime.IsZero() {
+			if err := c.appender.Flush(); err != nil {
 				return err
-			} else if err := c.checkAppender(true); err != nil {
+			}
+			written = i
+			rollover := time.Now()
+			if err := c.rollover(today); err != nil {
 				return err
 			}
+			c.metrics.rollovers++
+			c.metrics.rollover += time.Since(rollover)
+			// Sessions of the past day are never seen again
+			c.metrics.seen = nil
 		}
+		c.recent.add(hit)
+		c.metrics.add(hit, today)
+		c.appender.Buffer(hit)
+	}
+	if err := c.appender.Flush(); err != nil {
+		return err
+	}
+	written = len(hits)
+	return nil
+}
+
+// rollover merges the daily log into the history and starts a new log.
+func (c *Collector) rollover(today time.Time) error {
+	if c.shard != "" {
+		return c.rolloverShards(today)
+	}
+	if err := c.closeAppender(); err != nil {
+		return err
+	} else if err := c.checkHistoricalStats(); err != nil {
+		return err
+	} else if stats, err := c.readDailyStats([]string{filepath.Join(c.dir, dailyLog)}); err != nil {
+		return err
+	} else {
+		c.mergeAppender(stats)
+		c.pruneHistoricalStats()
+		c.retention.apply(c.history, today)
+		if err := c.saveHistoricalStats(); err != nil {
+			return err
+		}
+		return c.checkAppender(true)
 	}
-	return c.appender.Append(hit)
 }
 
 func (c *Collector) checkHistoricalStats() error {
@@ -131,31 +343,61 @@ func (c *Collector) checkHistoricalStats() error {
 	return nil
 }
 
+// saveHistoricalStats replaces the history file at once, so that it's never
+// read partially written.
 func (c *Collector) saveHistoricalStats() error {
-	return ioutil.WriteFile(filepath.Join(c.dir, historyLog), []byte(c.history.CSV()), 0666)
+	filename := filepath.Join(c.dir, historyLog)
+	if err := ioutil.WriteFile(filename+".tmp", []byte(c.history.CSV()), 0666); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
 }
 
 func (c *Collector) mergeAppender(daily *Stats) {
+	// Empty log, i.e. restored or merged stats, has nothing to merge
+	if daily.Start.IsZero() {
+		return
+	}
 	if c.history.Start.IsZero() {
 		c.history.Start = date(daily.Start)
 	}
-	n := int(date(daily.Start).Sub(c.history.Start)/(time.Hour*24)) + 1
+	n := len(c.history.Periods) + days(c.history.Start, date(daily.Start)) + 1
 	for i, frame := range c.history.frames() {
 		frame.Grow(n)
+		// The last column is recalculated from scratch, since the daily rows
+		// may be folded differently each time
+		for _, row := range frame.Rows {
+			if len(row.Values) > 0 {
+				row.Values[len(row.Values)-1] = 0
+			}
+		}
+		limit := c.frameLimits(frameNames[i]).History
 		for _, row := range daily.frames()[i].Rows {
 			total := 0
 			for _, v := range row.Values {
 				total = total + v
 			}
-			u := frame.Row(row.Name).Values
-			u[len(u)-1] = total
+			name := row.Name
+			if _, found := frame.find(name); !found && limit > 0 && len(frame.Rows) >= limit {
+				name = otherRow(frameNames[i], name)
+			}
+			u := frame.Row(name).Values
+			u[len(u)-1] += total
 		}
 	}
 }
 
+// pruneHistoricalStats folds the long-tail historical rows into the Other rows.
+func (c *Collector) pruneHistoricalStats() {
+	for i, frame := range c.history.frames() {
+		name := frameNames[i]
+		frame.prune(c.frameLimits(name).Prune, func(row string) string { return otherRow(name, row) })
+	}
+}
+
 func (c *Collector) checkAppender(truncate bool) error {
 	if c.appender == nil {
-		ap, err := NewAppender(filepath.Join(c.dir, dailyLog), truncate)
+		ap, err := NewAppender(filepath.Join(c.dir, c.logFile()), truncate)
 		if err != nil {
 			return err
 		}
@@ -164,8 +406,16 @@ func (c *Collector) checkAppender(truncate bool) error {
 	return nil
 }
 
-func (c *Collector) readDailyStats() (*Stats, error) {
-	return ParseAppendLog(filepath.Join(c.dir, dailyLog), c.location)
+func (c *Collector) readDailyStats(files []string) (*Stats, error) {
+	stats, err := parseAppendLog(files, c.location, c.goals, c.funnels)
+	if err != nil {
+		return nil, err
+	}
+	for i, frame := range stats.frames() {
+		name := frameNames[i]
+		frame.fold(c.frameLimits(name).Daily, func(row string) string { return otherRow(name, row) })
+	}
+	return stats, nil
 }
 
 // Stats returns the daily and overall statistic for the given collector. Daily
@@ -173,9 +423,23 @@ func (c *Collector) readDailyStats() (*Stats, error) {
 func (c *Collector) Stats() (*Stats, *Stats, error) {
 	c.Lock()
 	defer c.Unlock()
+	daily, history, err := c.localStats()
+	if err != nil {
+		return nil, nil, err
+	} else if len(c.replicas) > 0 {
+		return c.mergeReplicas(daily, history)
+	}
+	return daily, history, nil
+}
+
+// localStats returns the stats from the collector directory.
+func (c *Collector) localStats() (*Stats, *Stats, error) {
+	if c.shard != "" {
+		return c.sharedStats()
+	}
 	if err := c.checkHistoricalStats(); err != nil {
 		return nil, nil, err
-	} else if daily, err := c.readDailyStats(); err != nil {
+	} else if daily, err := c.readDailyStats([]string{filepath.Join(c.dir, dailyLog)}); err != nil {
 		return nil, nil, err
 	} else {
 		c.mergeAppender(daily)
@@ -184,6 +448,72 @@ func (c *Collector) Stats() (*Stats, *Stats, error) {
 	}
 }
 
+// replicaStats keeps the stats of a replica along with the state of its data
+// files they were read from.
+type replicaStats struct {
+	files   string
+	daily   *Stats
+	history *Stats
+}
+
+// replicaStats returns the stats of the replica data directory. They are
+// re-read only if any of its data files has changed since the last call.
+func (c *Collector) replicaStats(dir string) (*Stats, *Stats, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(files)
+	state := &strings.Builder{}
+	for _, filename := range files {
+		if fi, err := os.Stat(filename); err == nil {
+			fmt.Fprintf(state, "%s,%d,%d\n", filename, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	if cached := c.replicaData[dir]; cached != nil && cached.files == state.String() {
+		return cached.daily, cached.history, nil
+	}
+	r := New(Dir(dir), Location(c.location), Goals(c.goals...), Funnels(c.funnels...))
+	r.limits = c.limits
+	daily, history, err := r.Stats()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.replicaData == nil {
+		c.replicaData = map[string]*replicaStats{}
+	}
+	c.replicaData[dir] = &replicaStats{files: state.String(), daily: daily, history: history}
+	return daily, history, nil
+}
+
+// mergeReplicas returns the daily and historical stats combined with the ones
+// of the replicas. Daily stats of the replicas are merged only if they are
+// for the same day.
+func (c *Collector) mergeReplicas(daily, local *Stats) (*Stats, *Stats, error) {
+	history := &Stats{}
+	if err := history.Merge(local); err != nil {
+		return nil, nil, err
+	}
+	// Replicas that can not be read or merged are skipped, so that one of
+	// them can not break the report
+	for _, dir := range c.replicas {
+		d, h, err := c.replicaStats(dir)
+		if err != nil {
+			log.Printf("nullitics: replica %s: %v", dir, err)
+			continue
+		} else if err := history.Merge(h); err != nil {
+			log.Printf("nullitics: replica %s: %v", dir, err)
+			continue
+		}
+		if daily.Start.IsZero() || d.Start.IsZero() || d.Start.Equal(daily.Start) {
+			if err := daily.Merge(d); err != nil {
+				log.Printf("nullitics: replica %s: %v", dir, err)
+			}
+		}
+	}
+	return daily, history, nil
+}
+
 func (c *Collector) closeAppender() error {
 	if c.appender != nil {
 		if err := c.appender.Close(); err != nil {
@@ -194,8 +524,16 @@ func (c *Collector) closeAppender() error {
 	return nil
 }
 
-// Close shuts down the collector.
+// Close shuts down the collector. In the asynchronous mode it writes all the
+// queued hits first.
 func (c *Collector) Close() error {
+	if c.queue != nil {
+		c.closing.Lock()
+		c.stopOnce.Do(func() { close(c.stop) })
+		c.closing.Unlock()
+		<-c.done
+	}
+	c.salts.close()
 	c.Lock()
 	defer c.Unlock()
 	return c.closeAppender()
@@ -206,9 +544,24 @@ var gif = []byte{
 	0x01, 0x00, 0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x02,
 }
 
+// MaxBodySize is the largest accepted request body size for the POST API.
+var MaxBodySize int64 = 64 * 1024
+
 // ServeHTTP makes Collector implement a http.Handler interface. This handler
 // acts as an API and allows to collect stats via a tracking pixel or POST API.
+// POST API accepts the same parameters as the tracking pixel, either as a form
+// or as a JSON object.
 func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" || r.Method == "PUT" {
+		r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+			if err := parseJSONForm(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	hit := c.hit(r, true)
 	if r.Method == "GET" {
 		w.Header().Add("Content-Type", "image/gif")
 		w.Header().Set("Tk", "N")
@@ -217,17 +570,30 @@ func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Pragma", "no-cache")
 		_, _ = w.Write(gif)
 	} else if r.Method == "POST" || r.Method == "PUT" {
-		if r.Header.Get("Content-Type") == "application/json" {
-			// TODO: parse JSON and put values into query
-		}
 		w.WriteHeader(http.StatusNoContent)
 	}
-	_ = c.Hit(hit(r, c.salt, true))
+	_ = c.Hit(hit)
+}
+
+// parseJSONForm puts the values of the JSON object from the request body into
+// the request form, along with the URL query values.
+func parseJSONForm(r *http.Request) error {
+	m := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		return err
+	}
+	r.Form = r.URL.Query()
+	for k, v := range m {
+		if v != nil {
+			r.Form.Set(k, fmt.Sprint(v))
+		}
+	}
+	return nil
 }
 
 // Add allows to collect a hit caused by the given request.
 func (c *Collector) Add(r *http.Request) error {
-	return c.Hit(hit(r, c.salt, false))
+	return c.Hit(c.hit(r, false))
 }
 
 type responseWriter struct {
@@ -254,9 +620,41 @@ func (c *Collector) Collect(h http.Handler) http.Handler {
 	})
 }
 
-// Report returns a handler that renders the dashboard report for the collected stats
+// Report returns a handler that renders the dashboard report for the collected stats.
+//
+// Requests with "live" query parameter are handled by the Live handler, and
+// requests with "export" query parameter are handled by the Export handler.
+//
+// Requests with "from" and "to" query parameters (as YYYY-MM-DD, "to" is
+// exclusive) return the historical stats for the given range as JSON. Empty
+// "from" means the beginning of the history. Optional "bucket" parameter rolls
+// the days into "week" or "month" columns.
 func (c *Collector) Report(extra interface{}) http.Handler {
+	live, export := c.Live(), c.Export()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["live"]; ok {
+			live.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := r.URL.Query()["export"]; ok {
+			export.ServeHTTP(w, r)
+			return
+		}
+		if q := r.URL.Query(); q.Get("from") != "" || q.Get("to") != "" {
+			from, to, bucket, err := c.parseRange(q)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_, stats, err := c.historyRange(from, to, bucket)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(stats)
+			return
+		}
 		w.Header().Add("Content-Type", "text/html")
 		if err := c.report(w, extra); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -264,16 +662,86 @@ func (c *Collector) Report(extra interface{}) http.Handler {
 	})
 }
 
-func (c *Collector) report(w io.Writer, extra interface{}) error {
+// ReportTop is the maximum number of rows for each dimension, like paths or
+// referrers, that is sent to the dashboard.
+var ReportTop = 250
+
+// dateFormat is the date format of the report range queries.
+const dateFormat = "2006-01-02"
+
+// parseRange returns the date range and the bucket from the "from", "to" and
+// "bucket" query parameters. Empty "from" is returned as zero time.
+func (c *Collector) parseRange(q url.Values) (from, to time.Time, bucket string, err error) {
+	if s := q.Get("from"); s != "" {
+		if from, err = time.ParseInLocation(dateFormat, s, c.location); err != nil {
+			return from, to, bucket, err
+		}
+	}
+	if to, err = time.ParseInLocation(dateFormat, q.Get("to"), c.location); err != nil {
+		return from, to, bucket, err
+	}
+	bucket = q.Get("bucket")
+	if bucket != "" && bucket != Weekly && bucket != Monthly {
+		return from, to, bucket, errors.New("invalid bucket: " + bucket)
+	}
+	return from, to, bucket, nil
+}
+
+// historyRange returns the daily stats and the historical stats for the given
+// range, optionally bucketed by weeks or months, with at most ReportTop rows
+// for each dimension. Zero from time means the beginning of the history.
+func (c *Collector) historyRange(from, to time.Time, bucket string) (*Stats, *Stats, error) {
+	daily, stats, err := c.historySlice(from, to, bucket)
+	if err != nil {
+		return nil, nil, err
+	}
+	stats.Top(ReportTop)
+	return daily, stats, nil
+}
+
+// historySlice is like historyRange, but keeps all the rows. The range is
+// clamped to the beginning of the history and to the end of today.
+func (c *Collector) historySlice(from, to time.Time, bucket string) (*Stats, *Stats, error) {
 	daily, history, err := c.Stats()
+	if err != nil {
+		return nil, nil, err
+	}
+	// History is shared with the collector unless it's merged from replicas
+	c.Lock()
+	defer c.Unlock()
+	// The range is limited to the stored history, so that a request can not
+	// allocate the columns for arbitrary years
+	if end := date(c.now().In(c.location)).AddDate(0, 0, 1); to.After(end) {
+		to = end
+	}
+	if first := history.First(); from.Before(first) {
+		from = first
+	}
+	if history.First().IsZero() || from.After(to) {
+		from = to
+	}
+	stats := history.Slice(from, to)
+	if bucket != "" {
+		stats = stats.Bucket(bucket)
+	}
+	return daily, stats, nil
+}
+
+func (c *Collector) report(w io.Writer, extra interface{}) error {
+	// The dashboard shows the last 30 days by default, other ranges are
+	// requested separately
+	to := date(c.now().In(c.location))
+	from := to.AddDate(0, 0, -30)
+	daily, history, err := c.historyRange(from, to, "")
 	if err != nil {
 		return err
 	}
 	return ReportTemplate.ExecuteTemplate(w, "index.html", struct {
 		Daily   *Stats
 		History *Stats
+		Range   string
 		Extra   interface{}
-	}{daily, history, extra})
+	}{daily, history, from.Format(dateFormat) + "/" + to.Format(dateFormat), extra})
 }
 
 // RandomString is a helper utility to generate random string IDs, salts etc.
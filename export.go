@@ -0,0 +1,150 @@
+package nullitics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// hourFormat is the column format of the daily stats with hourly precision.
+const hourFormat = "2006-01-02T15:04"
+
+// columns returns the start dates of the stats columns, including the
+// downsampled periods, or the start hours for the daily stats.
+func (stats *Stats) columns() []string {
+	cols := []string{}
+	if stats.Interval > 0 && stats.Interval < 24*time.Hour {
+		for i := 0; i < stats.width(); i++ {
+			cols = append(cols, stats.Start.Add(time.Duration(i)*stats.Interval).Format(hourFormat))
+		}
+		return cols
+	}
+	for _, p := range stats.Periods {
+		cols = append(cols, p.Format(dateFormat))
+	}
+	for i := len(stats.Periods); i < stats.width(); i++ {
+		cols = append(cols, stats.Start.AddDate(0, 0, i-len(stats.Periods)).Format(dateFormat))
+	}
+	return cols
+}
+
+// WriteCSV writes the frame rows as CSV, one row per item and one column per
+// day or downsampled period. Empty frame name writes all frames with an extra
+// leading "frame" column.
+func (stats *Stats) WriteCSV(w io.Writer, frame string) error {
+	cw := csv.NewWriter(w)
+	cols := stats.columns()
+	header := append([]string{"name"}, cols...)
+	if frame == "" {
+		header = append([]string{"frame"}, header...)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for i, f := range stats.frames() {
+		if frame != "" && frame != frameNames[i] {
+			continue
+		}
+		for _, row := range f.Rows {
+			record := []string{row.Name}
+			if frame == "" {
+				record = append([]string{frameNames[i]}, record...)
+			}
+			for j := range cols {
+				record = append(record, fmt.Sprint(row.Get(j)))
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the frame rows with the column dates as JSON. Empty frame
+// name writes all frames, keyed by their names.
+func (stats *Stats) WriteJSON(w io.Writer, frame string) error {
+	v := map[string]interface{}{"Columns": stats.columns()}
+	if frame == "" {
+		frames := map[string]*Frame{}
+		for i, f := range stats.frames() {
+			frames[frameNames[i]] = f
+		}
+		v["Frames"] = frames
+	} else if f := stats.Frame(frame); f.Rows != nil {
+		v["Rows"] = f.Rows
+	} else {
+		v["Rows"] = []Row{}
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Export returns a handler that downloads the historical stats as CSV or JSON.
+//
+// The "export" query parameter is the frame name, like "URIs", or empty for
+// all frames. The "format" is either "csv" (default) or "json". The range is
+// given with "from" and "to" parameters like in the Report handler, and
+// defaults to the last 30 days. Like in the dashboard, a range of at most one
+// day ending today exports the daily stats with hourly columns.
+func (c *Collector) Export() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		frame, format := q.Get("export"), q.Get("format")
+		if frame != "" && (&Stats{}).Frame(frame) == nil {
+			http.Error(w, "invalid frame: "+frame, http.StatusBadRequest)
+			return
+		}
+		if format == "" {
+			format = "csv"
+		} else if format != "csv" && format != "json" {
+			http.Error(w, "invalid format: "+format, http.StatusBadRequest)
+			return
+		}
+		today := date(c.now().In(c.location))
+		if q.Get("to") == "" {
+			q.Set("to", today.Format(dateFormat))
+			if q.Get("from") == "" {
+				q.Set("from", today.AddDate(0, 0, -30).Format(dateFormat))
+			}
+		}
+		from, to, bucket, err := c.parseRange(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Like in the report, the range ends today at most
+		if to.After(today.AddDate(0, 0, 1)) {
+			to = today.AddDate(0, 0, 1)
+		}
+		daily, stats, err := c.historySlice(from, to, bucket)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := "stats"
+		if frame != "" {
+			name = frame
+		}
+		if bucket == "" && !from.IsZero() && !to.After(from.AddDate(0, 0, 1)) && to.Equal(today) {
+			stats = daily
+			if stats.Start.IsZero() {
+				stats.Start = today
+			}
+			name = name + "-" + stats.Start.Format(dateFormat)
+		} else if cols := stats.columns(); len(cols) > 0 {
+			name = name + "-" + cols[0] + "-" + to.AddDate(0, 0, -1).Format(dateFormat)
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="nullitics-%s.%s"`, name, format))
+		if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = stats.WriteJSON(w, frame)
+		} else {
+			w.Header().Set("Content-Type", "text/csv")
+			_ = stats.WriteCSV(w, frame)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package nullitics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsWriteCSV(t *testing.T) {
+	stats := &Stats{
+		Start:   time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+		Periods: []time.Time{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	stats.URIs.Grow(3)
+	stats.URIs.Row("/").Values = []int{10, 1, 2}
+	stats.URIs.Row("/a,b").Values = []int{0, 0, 3}
+	stats.Sessions.Grow(3)
+	stats.Sessions.Row("sessions").Values = []int{5, 1, 1}
+	for frame, csv := range map[string]string{
+		"URIs": "name,2021-01-01,2021-02-01,2021-02-02\n/,10,1,2\n\"/a,b\",0,0,3\n",
+		"Refs": "name,2021-01-01,2021-02-01,2021-02-02\n",
+		"": "frame,name,2021-01-01,2021-02-01,2021-02-02\nURIs,/,10,1,2\nURIs,\"/a,b\",0,0,3\n" +
+			"Sessions,sessions,5,1,1\n",
+	} {
+		b := &bytes.Buffer{}
+		if err := stats.WriteCSV(b, frame); err != nil || b.String() != csv {
+			t.Error(frame, b.String(), err)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	for i := 0; i < 10; i++ {
+		if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "a", Country: "DE"}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(24 * time.Hour)
+	}
+	w := httptest.NewRecorder()
+	c.Report(nil).ServeHTTP(w, httptest.NewRequest("GET", "/?export=Countries&from=2021-01-03&to=2021-01-06", nil))
+	if w.Body.String() != "name,2021-01-03,2021-01-04,2021-01-05\nDE,1,1,1\n" {
+		t.Error(w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="nullitics-Countries-2021-01-03-2021-01-05.csv"` {
+		t.Error(cd)
+	}
+	// JSON export of all frames defaults to the last 30 days of the history
+	w = httptest.NewRecorder()
+	c.Export().ServeHTTP(w, httptest.NewRequest("GET", "/?export=&format=json", nil))
+	v := struct {
+		Columns []string
+		Frames  map[string]Frame
+	}{}
+	if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Columns) != 10 || v.Columns[9] != "2021-01-10" || v.Frames["URIs"].Rows[0].Values[9] != 1 {
+		t.Error(v)
+	}
+	// Today is exported with the hourly daily stats, like shown in the dashboard
+	if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	c.Export().ServeHTTP(w, httptest.NewRequest("GET", "/?export=URIs&from=2021-01-11&to=2021-01-11", nil))
+	lines := strings.Split(w.Body.String(), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "name,2021-01-11T00:00,2021-01-11T01:00,") ||
+		lines[1] != "/,0,0,0,0,0,0,0,0,0,0,1,0,0,0,0,0,0,0,0,0,0,0,0,0" {
+		t.Error(w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="nullitics-URIs-2021-01-11.csv"` {
+		t.Error(cd)
+	}
+	// Far future ranges are limited to the stored history
+	w = httptest.NewRecorder()
+	c.Export().ServeHTTP(w, httptest.NewRequest("GET", "/?export=URIs&from=0001-01-01&to=9999-12-31", nil))
+	if lines := strings.Split(w.Body.String(), "\n"); w.Code != 200 || len(lines) != 3 || strings.Count(lines[0], ",") != 11 {
+		t.Error(w.Code, w.Body.Len())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="nullitics-URIs-2021-01-01-2021-01-11.csv"` {
+		t.Error(cd)
+	}
+	for _, target := range []string{"/?export=Foo", "/?export=URIs&format=xml", "/?export=URIs&from=yesterday"} {
+		w := httptest.NewRecorder()
+		c.Export().ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != 400 {
+			t.Error(target, w.Code)
+		}
+	}
+}
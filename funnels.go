@@ -0,0 +1,70 @@
+package nullitics
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Funnel is an ordered sequence of steps. A session reaches a step when it
+// matches the step after having reached all the previous steps, other hits in
+// between are allowed.
+type Funnel struct {
+	Name  string
+	Steps []Goal
+}
+
+// ParseFunnel parses a funnel definition in the form of
+// "name=/step1,/step2,event:name". Each step is named after its definition.
+// It returns false if the definition is malformed or the names contain commas,
+// line breaks or "|".
+func ParseFunnel(s string) (Funnel, bool) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || !validName(parts[0]) || parts[1] == "" {
+		return Funnel{}, false
+	}
+	funnel := Funnel{Name: parts[0]}
+	for _, step := range strings.Split(parts[1], ",") {
+		goal, ok := ParseGoal(step + "=" + step)
+		if !ok {
+			return Funnel{}, false
+		}
+		funnel.Steps = append(funnel.Steps, goal)
+	}
+	return funnel, true
+}
+
+// valid returns true if the funnel and step names can be stored as stats row
+// names.
+func (f *Funnel) valid() bool {
+	if !validName(f.Name) || len(f.Steps) == 0 {
+		return false
+	}
+	for _, step := range f.Steps {
+		if !validName(step.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// funnelStep returns the row name for the i-th step of the funnel in the
+// Funnels frame, i.e. "Signup|1|/pricing".
+func funnelStep(f *Funnel, i int) string {
+	return f.Name + goalSep + strconv.Itoa(i+1) + goalSep + f.Steps[i].Name
+}
+
+// advance moves the session through the funnels, counting each step reached.
+func (v *visit) advance(stats *Stats, funnels []Funnel, kind, uri string, hour int) {
+	for i := range funnels {
+		f := &funnels[i]
+		if v.steps == nil {
+			v.steps = make([]int, len(funnels))
+		}
+		n := v.steps[i]
+		if n >= len(f.Steps) || !f.Steps[n].Match(kind, uri) {
+			continue
+		}
+		v.steps[i]++
+		stats.Funnels.Row(funnelStep(f, n)).Values[hour]++
+	}
+}
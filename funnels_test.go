@@ -0,0 +1,80 @@
+package nullitics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFunnel(t *testing.T) {
+	f, ok := ParseFunnel("Signup=/pricing,/signup/*,event:welcome")
+	if !ok || !reflect.DeepEqual(f, Funnel{Name: "Signup", Steps: []Goal{
+		{Name: "/pricing", Path: "/pricing"},
+		{Name: "/signup/*", Path: "/signup/*"},
+		{Name: "event:welcome", Event: "welcome"},
+	}}) {
+		t.Error(f, ok)
+	}
+	for _, s := range []string{"", "Signup", "=/pricing", "Signup=", "Signup=/pricing,,/signup",
+		"Sign|up=/pricing", "Sign\nup=/pricing", "Signup=/pricing|/signup", "Signup=/pricing\r"} {
+		if _, ok := ParseFunnel(s); ok {
+			t.Error(s)
+		}
+	}
+}
+
+func TestFunnelsInvalidName(t *testing.T) {
+	c := New(Dir(t.TempDir()), Location(time.UTC), Funnels(
+		Funnel{Name: "Sign,up", Steps: []Goal{{Name: "/signup", Path: "/signup"}}},
+		Funnel{Name: "Signup", Steps: []Goal{{Name: "/a|b", Path: "/signup"}}},
+		Funnel{Name: "Signup", Steps: []Goal{{Name: "/signup", Path: "/signup"}}},
+	))
+	defer c.Close()
+	if len(c.funnels) != 1 || c.funnels[0].Steps[0].Name != "/signup" {
+		t.Error(c.funnels)
+	}
+}
+
+func TestFunnelSteps(t *testing.T) {
+	f, _ := ParseFunnel("Signup=/pricing,/signup,/welcome")
+	c := New(Dir(t.TempDir()), Location(time.UTC), Funnels(f))
+	defer c.Close()
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, hit := range []*Hit{
+		// Completes the funnel with other pages in between
+		{Timestamp: ts, URI: "/pricing", Session: "a"},
+		{Timestamp: ts, URI: "/about", Session: "a"},
+		{Timestamp: ts, URI: "/signup", Session: "a"},
+		{Timestamp: ts.Add(time.Hour), URI: "/welcome", Session: "a"},
+		{Timestamp: ts.Add(time.Hour), URI: "/pricing", Session: "a"},
+		// Steps out of order are not counted
+		{Timestamp: ts, URI: "/signup", Session: "b"},
+		{Timestamp: ts, URI: "/pricing", Session: "b"},
+		{Timestamp: ts, URI: "/welcome", Session: "b"},
+		// Drops off after the first step
+		{Timestamp: ts, URI: "/pricing", Session: "c"},
+	} {
+		if err := c.Hit(hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	daily, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row, n := range map[string]int{
+		"Signup|1|/pricing": 3,
+		"Signup|2|/signup":  1,
+		"Signup|3|/welcome": 1,
+	} {
+		if v := daily.Funnels.Row(row).Last(24); v != n {
+			t.Error(row, v, n)
+		}
+	}
+	if v := daily.Funnels.Row("Signup|3|/welcome").Values; v[11] != 1 {
+		t.Error(v)
+	}
+	if n := history.Funnels.Row("Signup|2|/signup").Last(1); n != 1 {
+		t.Error(n)
+	}
+}
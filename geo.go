@@ -6,14 +6,16 @@ import (
 	"encoding/csv"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"sort"
 	"strings"
 )
 
-// GeoDB is a file location for a GeoLite CSV database. By default the location
-// is set to $GEODB environment variable, but can be changed if needed.
+// GeoDB is the default GeoFinder for collectors created without the Geo
+// option. By default it is loaded from the GeoLite CSV database at the $GEODB
+// environment variable location, but can be changed if needed.
 var GeoDB GeoFinder
 
 func init() {
@@ -30,6 +32,95 @@ type GeoFinder interface {
 	Find(ip string) string
 }
 
+// IPChecker is an interface, that can tell if the IP address belongs to a
+// certain set of networks, i.e. known datacenter or cloud hosting ranges.
+type IPChecker interface {
+	Contains(ip string) bool
+}
+
+// ipList keeps the IPv4 and IPv6 address spans separately, each sorted by
+// the first address. Nested and overlapping networks are merged, so that the
+// spans never overlap.
+type ipList struct {
+	v4 []ipSpan
+	v6 []ipSpan
+}
+
+// ipSpan is a range of addresses from first to last, inclusive.
+type ipSpan struct {
+	first net.IP
+	last  net.IP
+}
+
+// Contains returns true if the IPv4 or IPv6 address belongs to one of the
+// networks.
+func (l *ipList) Contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	} else if ip4 := ip.To4(); ip4 != nil {
+		return findSpan(l.v4, ip4)
+	}
+	return findSpan(l.v6, ip)
+}
+
+// findSpan returns true if the IP address is within one of the sorted
+// non-overlapping spans. The IP must be of the same length as the spans.
+func findSpan(spans []ipSpan, ip net.IP) bool {
+	// The candidate is the last span that starts at or before the address
+	i := sort.Search(len(spans), func(i int) bool { return bytes.Compare(spans[i].first, ip) > 0 })
+	return i > 0 && bytes.Compare(ip, spans[i-1].last) <= 0
+}
+
+// mergeSpans sorts the spans by the first address and merges the overlapping
+// ones.
+func mergeSpans(spans []ipSpan) []ipSpan {
+	sort.Slice(spans, func(i, j int) bool { return bytes.Compare(spans[i].first, spans[j].first) < 0 })
+	merged := spans[:0]
+	for _, span := range spans {
+		if n := len(merged); n > 0 && bytes.Compare(span.first, merged[n-1].last) <= 0 {
+			if bytes.Compare(span.last, merged[n-1].last) > 0 {
+				merged[n-1].last = span.last
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// NewIPList reads a text file with one IPv4 or IPv6 network in CIDR notation
+// per line and returns an IPChecker for these networks. Empty lines and lines
+// starting with "#" are ignored.
+func NewIPList(filename string) (IPChecker, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	l := &ipList{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, err
+		}
+		span := ipSpan{first: ipnet.IP, last: make(net.IP, len(ipnet.IP))}
+		for i := range ipnet.IP {
+			span.last[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+		}
+		if len(ipnet.IP) == net.IPv4len {
+			l.v4 = append(l.v4, span)
+		} else {
+			l.v6 = append(l.v6, span)
+		}
+	}
+	l.v4, l.v6 = mergeSpans(l.v4), mergeSpans(l.v6)
+	return l, nil
+}
+
 type ipRange struct {
 	Net     *net.IPNet
 	Country string
@@ -140,11 +231,21 @@ func (db geodb) Find(ipv4 string) string {
 	if ip == nil {
 		return ""
 	}
-	i := sort.Search(len(db), func(i int) bool {
-		return bytes.Compare(db[i].Net.IP, ip) > 0 || db[i].Net.Contains(ip)
-	})
-	if i < len(db) && db[i].Net.Contains(ip) {
-		return db[i].Country
+	if r := findRange(db, ip); r != nil {
+		return r.Country
 	}
 	return ""
 }
+
+// findRange returns the range containing the IP address in the ranges sorted
+// by the network address, or nil. The IP must be of the same length as the
+// network addresses.
+func findRange(ranges []ipRange, ip net.IP) *ipRange {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return bytes.Compare(ranges[i].Net.IP, ip) > 0 || ranges[i].Net.Contains(ip)
+	})
+	if i < len(ranges) && ranges[i].Net.Contains(ip) {
+		return &ranges[i]
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package nullitics
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -19,3 +21,58 @@ func TestGeoDB(t *testing.T) {
 	cn = db.Find("127.0.0.1")
 	t.Log(cn)
 }
+
+func TestIPList(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "datacenters.txt")
+	list := "# comment\n10.0.0.0/8\n\n192.168.1.0/24\n1.2.3.0/24\n2001:db8::/32\n"
+	if err := ioutil.WriteFile(filename, []byte(list), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := NewIPList(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, ok := range map[string]bool{
+		"10.1.2.3":        true,
+		"1.2.3.4":         true,
+		"192.168.1.5":     true,
+		"192.168.2.5":     false,
+		"8.8.8.8":         false,
+		"2001:db8::1":     true,
+		"2001:db9::1":     false,
+		"::ffff:10.1.2.3": true,
+		"invalid":         false,
+	} {
+		if dc.Contains(ip) != ok {
+			t.Error(ip, ok)
+		}
+	}
+}
+
+func TestIPListNested(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "datacenters.txt")
+	list := "10.0.0.0/8\n10.1.0.0/16\n10.2.0.0/16\n10.2.3.0/24\n11.0.0.0/16\n11.0.128.0/17\n" +
+		"2001:db8::/32\n2001:db8:1::/48\n2001:db8:2::/48\n"
+	if err := ioutil.WriteFile(filename, []byte(list), 0666); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := NewIPList(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, ok := range map[string]bool{
+		"10.3.0.1":       true,
+		"10.2.3.4":       true,
+		"10.255.255.255": true,
+		"11.0.200.1":     true,
+		"11.1.0.1":       false,
+		"9.255.255.255":  false,
+		"2001:db8:3::1":  true,
+		"2001:db8:2::1":  true,
+		"2001:db9::1":    false,
+	} {
+		if dc.Contains(ip) != ok {
+			t.Error(ip, ok)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package nullitics
+
+import (
+	"path"
+	"strings"
+)
+
+// Event hit kind, a custom event sent via the API
+const Event = "event"
+
+// goalSep separates goal names from the dimension values in the goal
+// breakdown frames, i.e. "Signup|google.com".
+const goalSep = "|"
+
+// Goal is a conversion goal. A session converts when one of its page views
+// matches the Path pattern (see path.Match for the syntax), or when one of its
+// events has the Event name.
+type Goal struct {
+	Name  string
+	Path  string
+	Event string
+}
+
+// Match returns true if the hit of the given kind and URI matches the goal.
+func (g *Goal) Match(kind, uri string) bool {
+	switch kind {
+	case "":
+		if g.Path == "" {
+			return false
+		}
+		ok, _ := path.Match(g.Path, uri)
+		return ok
+	case Event:
+		return g.Event != "" && g.Event == uri
+	}
+	return false
+}
+
+// validName returns true if the goal or funnel name can be stored as a stats
+// row name, i.e. it has no CSV separators or the goal separator.
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, ",\n\r"+goalSep)
+}
+
+// ParseGoal parses a goal definition in the form of "name=/path/pattern" or
+// "name=event:name". It returns false if the definition is malformed or the
+// name contains commas, line breaks or "|".
+func ParseGoal(s string) (Goal, bool) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || !validName(parts[0]) || parts[1] == "" {
+		return Goal{}, false
+	}
+	if strings.HasPrefix(parts[1], "event:") {
+		return Goal{Name: parts[0], Event: strings.TrimPrefix(parts[1], "event:")}, true
+	}
+	return Goal{Name: parts[0], Path: parts[1]}, true
+}
+
+// visit keeps track of a single session within the daily log.
+type visit struct {
+	ref     string
+	country string
+	device  string
+	viewed  bool
+	goals   []bool
+	steps   []int
+}
+
+// convert records the goal conversions for the hit, at most once per session.
+func (v *visit) convert(stats *Stats, goals []Goal, kind, uri string, hour int) {
+	for i := range goals {
+		g := &goals[i]
+		if !g.Match(kind, uri) {
+			continue
+		}
+		if v.goals == nil {
+			v.goals = make([]bool, len(goals))
+		}
+		if v.goals[i] {
+			continue
+		}
+		v.goals[i] = true
+		stats.Goals.Row(g.Name).Values[hour]++
+		if v.ref != "" {
+			stats.GoalRefs.Row(g.Name + goalSep + v.ref).Values[hour]++
+		}
+		if v.country != "" {
+			stats.GoalCountries.Row(g.Name + goalSep + v.country).Values[hour]++
+		}
+		if v.device != "" {
+			stats.GoalDevices.Row(g.Name + goalSep + v.device).Values[hour]++
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package nullitics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGoal(t *testing.T) {
+	for s, goal := range map[string]Goal{
+		"Signup=/signup/*":           {Name: "Signup", Path: "/signup/*"},
+		"Subscribe=event:newsletter": {Name: "Subscribe", Event: "newsletter"},
+	} {
+		if g, ok := ParseGoal(s); !ok || g != goal {
+			t.Error(s, g, ok)
+		}
+	}
+	for _, s := range []string{"", "Signup", "=/signup", "Signup=", "Sign,up=/signup", "Sign|up=/signup", "Sign\nup=/signup"} {
+		if _, ok := ParseGoal(s); ok {
+			t.Error(s)
+		}
+	}
+}
+
+func TestGoalMatch(t *testing.T) {
+	page := Goal{Name: "Docs", Path: "/docs/*"}
+	event := Goal{Name: "Play", Event: "play"}
+	for _, test := range []struct {
+		goal  Goal
+		kind  string
+		uri   string
+		match bool
+	}{
+		{page, "", "/docs/intro", true},
+		{page, "", "/docs", false},
+		{page, "", "/docs/a/b", false},
+		{page, Event, "/docs/intro", false},
+		{event, Event, "play", true},
+		{event, "", "play", false},
+		{event, Event, "pause", false},
+	} {
+		if test.goal.Match(test.kind, test.uri) != test.match {
+			t.Error(test)
+		}
+	}
+}
+
+func TestGoalConversions(t *testing.T) {
+	c := New(Dir(t.TempDir()), Location(time.UTC), Goals(
+		Goal{Name: "Signup", Path: "/signup/done"},
+		Goal{Name: "Play", Event: "play"},
+	))
+	defer c.Close()
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, hit := range []*Hit{
+		{Timestamp: ts, URI: "/", Session: "a", Ref: "google.com", Country: "DE", Device: Desktop},
+		{Timestamp: ts, URI: "/signup/done", Session: "a", Country: "DE", Device: Desktop},
+		{Timestamp: ts, URI: "/signup/done", Session: "a", Country: "DE", Device: Desktop},
+		{Timestamp: ts, URI: "play", Session: "a", Kind: Event},
+		{Timestamp: ts, URI: "/", Session: "b", Ref: "t.co", Country: "FR", Device: Mobile},
+		{Timestamp: ts.Add(time.Hour), URI: "/signup/done", Session: "b", Country: "FR", Device: Mobile},
+		{Timestamp: ts, URI: "/", Session: "c", Country: "FR", Device: Mobile},
+	} {
+		if err := c.Hit(hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	daily, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := daily.Goals.Row("Signup").Values; v[10] != 1 || v[11] != 1 {
+		t.Error(v)
+	}
+	if n := daily.Goals.Row("Play").Last(24); n != 1 {
+		t.Error(n)
+	}
+	if n := daily.GoalRefs.Row("Signup|google.com").Last(24); n != 1 {
+		t.Error(n)
+	}
+	if n := daily.GoalCountries.Row("Signup|FR").Last(24); n != 1 {
+		t.Error(n)
+	}
+	if n := daily.GoalDevices.Row("Play|desktop").Last(24); n != 1 {
+		t.Error(n)
+	}
+	// Events are not page views
+	if _, ok := daily.URIs.find("play"); ok {
+		t.Error(daily.URIs)
+	}
+	if n := daily.Sessions.Row("sessions").Last(24); n != 3 {
+		t.Error(n)
+	}
+	// Goal totals are kept in history
+	if n := history.Goals.Row("Signup").Last(1); n != 2 {
+		t.Error(n)
+	}
+}
+
+func TestGoalsInvalidName(t *testing.T) {
+	c := New(Dir(t.TempDir()), Location(time.UTC), Goals(
+		Goal{Name: "Sign,up", Path: "/signup"},
+		Goal{Name: "Signup", Path: "/signup"},
+	))
+	defer c.Close()
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{ts, ts.Add(24 * time.Hour)} {
+		if err := c.Hit(&Hit{Timestamp: ts, URI: "/signup", Session: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stats survive the rollover
+	_, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Goals.Rows) != 1 || history.Goals.Rows[0].Name != "Signup" {
+		t.Error(history.Goals.Rows)
+	}
+}
@@ -1,11 +1,12 @@
 package nullitics
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	_ "embed" // embed package must be imported for embedded files to work
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,10 +17,19 @@ const (
 	Mobile = "mobile"
 	// Desktop device type
 	Desktop = "desktop"
+	// Bot device type, used for the filtered bot and crawler hits
+	Bot = "bot"
+
+	// Outbound hit kind, a click on an external link
+	Outbound = "outbound"
+	// Download hit kind, a click on a file download link
+	Download = "download"
 )
 
 var (
-	// IPHeaders are request headers, containing the real user IP address
+	// IPHeaders are request headers, containing the real user IP address. They
+	// are checked after the standard Forwarded header, and only for requests
+	// coming from trusted proxies.
 	IPHeaders = []string{"X-Real-IP", "X-Forwarded-For"}
 	// MobileUAs are user-agent substrings, typical only for mobile devices
 	MobileUAs = []string{"iPhone", "iPad", "Android"}
@@ -27,11 +37,35 @@ var (
 	MobileBreakpoint = 992
 	// SkipSubdomains is a list of common subdomains to skip in referrers
 	SkipSubdomains = []string{"www.", "www1.", "www2.", "www3.", "www4.", "m.", "l.", "lm.", "i.", "old."}
-	// BotAgents is a list of substrings commonly met in bot/crawler User-Agent strings
-	BotAgents = []string{"bot", "crawler", "spider", "spyder", "search", "worm", "fetch", "nutch", "http://", "https://"}
+	// BotAgents is a list of generic substrings commonly met in bot/crawler User-Agent strings
+	BotAgents = []string{"bot", "crawler", "spider", "spyder", "worm", "fetch", "nutch", "http://", "https://"}
+	// BotPatterns matches User-Agent strings of known bots, crawlers, monitoring
+	// tools, link preview fetchers and headless browsers. It is compiled from
+	// the embedded bots.txt signature list.
+	BotPatterns = compileBotPatterns(botsTxt)
 )
 
-// Hit is a basic data type describing a single page visit or event.
+//go:embed bots.txt
+var botsTxt string
+
+func compileBotPatterns(list string) *regexp.Regexp {
+	patterns := []string{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if len(line) > 2 && line[0] == '/' && line[len(line)-1] == '/' {
+			patterns = append(patterns, line[1:len(line)-1])
+		} else {
+			patterns = append(patterns, regexp.QuoteMeta(line))
+		}
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(patterns, "|"))
+}
+
+// Hit is a basic data type describing a single page visit or event. Page
+// visits have an empty Kind.
 type Hit struct {
 	Timestamp time.Time
 	URI       string
@@ -39,6 +73,11 @@ type Hit struct {
 	Ref       string
 	Country   string
 	Device    string
+	Channel   string
+	Kind      string
+	// err is set if the hit can not be recorded, i.e. the session salt is
+	// not available
+	err error
 }
 
 func isMobileUserAgent(ua string) bool {
@@ -57,26 +96,99 @@ func isMobileScreen(size string) bool {
 
 // IPAddr returns the (most likely) real user IP address. Nullitics does not store
 // any of the IP addresses, however they may be used to detect user location
-// and identify sessions.
-func ipaddr(r *http.Request) string {
+// and identify sessions. Forwarding headers are only honoured if the request
+// comes from a trusted proxy, in which case the proxy chain is walked from the
+// right and the first untrusted hop is returned.
+func ipaddr(r *http.Request, proxies []*net.IPNet) string {
+	remote := hopIP(r.RemoteAddr)
+	if remote == nil {
+		return ""
+	}
+	if !isTrusted(remote, proxies) {
+		return remote.String()
+	}
+	chains := [][]string{forwardedFor(r.Header.Values("Forwarded"))}
 	for _, hdr := range IPHeaders {
-		if fields := strings.Fields(r.Header.Get(hdr)); len(fields) > 0 {
-			if ip := strings.TrimRight(fields[0], ","); ip != "" {
+		chains = append(chains, splitHops(r.Header.Values(hdr)))
+	}
+	for _, hops := range chains {
+		if len(hops) > 0 {
+			if ip := lastUntrusted(hops, proxies); ip != "" {
 				return ip
 			}
+			break
+		}
+	}
+	return remote.String()
+}
+
+func isTrusted(ip net.IP, proxies []*net.IPNet) bool {
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastUntrusted returns the right-most hop of the proxy chain that is not a
+// trusted proxy. Empty string is returned if the chain is malformed.
+func lastUntrusted(hops []string, proxies []*net.IPNet) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := hopIP(hops[i])
+		if ip == nil {
+			return ""
+		}
+		if i == 0 || !isTrusted(ip, proxies) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// hopIP parses a single proxy chain element, which may be a quoted IP address,
+// possibly with a port number and with IPv6 addresses in square brackets.
+func hopIP(s string) net.IP {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if strings.HasPrefix(s, "[") {
+		if i := strings.IndexByte(s, ']'); i > 0 {
+			s = s[1:i]
+		}
+	} else if net.ParseIP(s) == nil {
+		if host, _, err := net.SplitHostPort(s); err == nil {
+			s = host
+		}
+	}
+	return net.ParseIP(s)
+}
+
+// splitHops returns a list of addresses from comma-separated header values,
+// such as X-Forwarded-For.
+func splitHops(values []string) (hops []string) {
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
 		}
 	}
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return ip
+	return hops
 }
 
-// Session returns a hash of the user IP address, user agent, current date and
-// a salt string. It is unique enough for most typical cases, and does not
-// violate user's privacy since no personal data is stored within a session.
-func session(ip, ua, salt string) string {
-	s := ip + date(Now()).Format("20060102") + ua + salt
-	hash := md5.Sum([]byte(s))
-	return hex.EncodeToString(hash[:4])
+// forwardedFor returns a list of "for" addresses from the RFC 7239 Forwarded
+// header values.
+func forwardedFor(values []string) (hops []string) {
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hops = append(hops, kv[1])
+				}
+			}
+		}
+	}
+	return hops
 }
 
 // Lang returns country/language code from the request Accept-Language header.
@@ -97,6 +209,8 @@ func validateURI(uri string) string {
 	if uri == "" {
 		return "/"
 	}
+	// Escaped like in the log file to keep it within the length limit
+	uri = logReplacer.Replace(uri)
 	if len(uri) > MaxPathLength {
 		return uri[:MaxPathLength-1]
 	}
@@ -104,82 +218,153 @@ func validateURI(uri string) string {
 }
 
 func isBot(ua string) bool {
+	if ua == "" {
+		return true
+	}
 	s := strings.ToLower(ua)
 	for _, b := range BotAgents {
 		if strings.Contains(s, b) {
 			return true
 		}
 	}
-	return false
+	return BotPatterns.MatchString(ua)
 }
 
-func validateRef(ref string) string {
+// isBotRequest checks the User-Agent, as well as other heuristics: real
+// browsers always send User-Agent and Accept-Language headers (unless the
+// checks are disabled with BotHeuristics), and rarely come from the
+// datacenter IP ranges.
+func (c *Collector) isBotRequest(r *http.Request, ip string) bool {
+	if ua := r.UserAgent(); ua == "" {
+		if !c.allowNoUA {
+			return true
+		}
+	} else if isBot(ua) {
+		return true
+	}
+	if !c.allowNoLang && r.Header.Get("Accept-Language") == "" {
+		return true
+	}
+	return c.datacenters != nil && c.datacenters.Contains(ip)
+}
+
+// refHost returns the referrer host name without common subdomains.
+func refHost(ref string) string {
 	u, err := url.Parse(ref)
 	if err != nil {
 		return ""
 	}
-	host := u.Hostname()
+	return skipSubdomains(strings.ToLower(u.Hostname()))
+}
+
+func skipSubdomains(host string) string {
 	for _, sub := range SkipSubdomains {
 		if strings.HasPrefix(host, sub) {
-			host = strings.TrimPrefix(host, sub)
-			break
+			return strings.TrimPrefix(host, sub)
 		}
 	}
-	if strings.HasSuffix(host, ".google.com") ||
-		strings.HasPrefix(host, "google.co.") ||
-		strings.HasPrefix(host, "google.com.") ||
-		(len(host) < 13 && strings.HasPrefix(host, "google.")) ||
-		host == "com.google.android.googlequicksearchbox" ||
-		host == "com.google.android.gm" {
-		host = "google.com"
-	} else if strings.HasSuffix(host, ".bing.com") {
-		host = "bing.com"
-	} else if strings.HasSuffix(host, ".duckduckgo.com") {
-		host = "duckduckgo.com"
-	} else if strings.HasSuffix(host, ".reddit.com") {
-		host = "reddit.com"
-	} else if strings.HasSuffix(host, ".wikipedia.org") {
-		host = "wikipedia.org"
+	return host
+}
+
+// isInternal returns true if the referrer host is the site itself, either one
+// of the configured collector hosts, or the host of the visited page.
+func (c *Collector) isInternal(ref, site string) bool {
+	if ref == "" {
+		return false
+	}
+	if len(c.hosts) == 0 {
+		return ref == skipSubdomains(strings.ToLower(site))
 	}
+	for _, host := range c.hosts {
+		if ref == skipSubdomains(strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRef returns the canonical referrer name and its traffic channel.
+func validateRef(ref string, rules RefRules) (string, string) {
+	host, channel := rules.Find(refHost(ref))
 	if len(host) > MaxRefLength {
 		host = host[:MaxRefLength-1]
 	}
-	return host
+	return host, channel
 }
 
-func hit(r *http.Request, salt string, api bool) *Hit {
+func (c *Collector) hit(r *http.Request, api bool) *Hit {
 	// TODO: handle DNT
 	// Create a hit object with current timestamp
-	hit := &Hit{Timestamp: Now()}
-	// Skip bots
-	if isBot(r.UserAgent()) {
+	now := c.now()
+	hit := &Hit{Timestamp: now}
+	// Skip bots, but keep track of them
+	ip := ipaddr(r, c.proxies)
+	if c.isBotRequest(r, ip) {
+		hit.Device = Bot
 		return hit
 	}
 	// If collector is used as a middleware - use request Path, otherwise use r.Referer path
+	medium, site := "", ""
 	if api {
-		u, err := url.Parse(r.URL.Query().Get("u"))
+		u, err := url.Parse(r.FormValue("u"))
 		if u == nil || u.String() == "" || err != nil {
 			u, err = url.Parse(r.Referer())
 		}
 		if err != nil {
 			return hit
 		}
-		hit.URI = u.Path
+		hit.URI = c.paths.normalize(u)
+		if r.FormValue("h") != "" && u.Fragment != "" {
+			// Hash-based routes are tracked as separate pages
+			hit.URI = hit.URI + "#" + u.Fragment
+		}
 		hit.Ref = u.Query().Get("utm_source")
+		medium = u.Query().Get("utm_medium")
+		site = u.Hostname()
+		// Outbound links and downloads are recorded as the link URL, events are
+		// recorded by their name
+		if k := r.FormValue("k"); k == Event {
+			hit.Kind = k
+			hit.URI = r.FormValue("e")
+			if hit.URI == "" {
+				return hit
+			}
+		} else if k == Outbound || k == Download {
+			link, err := url.Parse(r.FormValue("l"))
+			if err != nil || link.Host == "" {
+				return hit
+			}
+			hit.Kind = k
+			hit.URI = link.Host + link.Path
+			if link.Hostname() == site {
+				hit.URI = link.Path
+			}
+		}
 	} else {
-		hit.URI = r.URL.Path
+		hit.URI = c.paths.normalize(r.URL)
 		hit.Ref = r.URL.Query().Get("utm_source")
+		medium = r.URL.Query().Get("utm_medium")
+		site = (&url.URL{Host: r.Host}).Hostname()
 	}
 	// Validate URI
 	hit.URI = validateURI(hit.URI)
 	// Create Session hash
-	ip := ipaddr(r)
-	hit.Session = session(ip, r.UserAgent(), salt)
+	key, err := c.salts.get(now.In(c.location))
+	if err != nil {
+		hit.err = fmt.Errorf("session salt: %w", err)
+		return hit
+	}
+	hit.Session = session(ip, r.UserAgent(), c.salt, key)
 	// Fill referrer and validate its value
 	if api && hit.Ref == "" {
 		hit.Ref = r.FormValue("r")
 	}
-	hit.Ref = validateRef(hit.Ref)
+	// Drop self-referrals, i.e. the navigation within the site
+	if c.isInternal(refHost(hit.Ref), site) {
+		hit.Ref = ""
+	}
+	hit.Ref, hit.Channel = validateRef(hit.Ref, c.refs)
+	hit.Channel = channel(hit.Channel, medium)
 	// Get device type via API parameters or via user agent
 	if (api && isMobileScreen(r.FormValue("d"))) || isMobileUserAgent(r.UserAgent()) {
 		hit.Device = Mobile
@@ -189,7 +374,7 @@ func hit(r *http.Request, salt string, api bool) *Hit {
 	// Get ISO country code from IP address (if possible), or from Accept-Language header
 	if cn := r.FormValue("c"); api && cn != "" {
 		hit.Country = cn
-	} else if cn := GeoDB.Find(ip); cn != "" {
+	} else if cn := c.geo.Find(ip); cn != "" {
 		hit.Country = cn
 	} else {
 		hit.Country = lang(r)
@@ -0,0 +1,208 @@
+package nullitics
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:85.0) Gecko/20100101 Firefox/85.0"
+
+// browserRequest returns a test request that looks like it came from a real browser
+func browserRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("User-Agent", firefox)
+	r.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	return r
+}
+
+type geoMap map[string]string
+
+func (m geoMap) Find(ip string) string { return m[ip] }
+
+func TestHitGeo(t *testing.T) {
+	a := New(Geo(geoMap{"1.2.3.4": "DE"}))
+	b := New(Geo(geoMap{"1.2.3.4": "FR"}))
+	r := browserRequest("GET", "/null.gif?u=http://example.com/foo")
+	r.RemoteAddr = "1.2.3.4:5678"
+	if hit := a.hit(r, true); hit.Country != "DE" {
+		t.Error(hit)
+	}
+	if hit := b.hit(r, true); hit.Country != "FR" {
+		t.Error(hit)
+	}
+}
+
+func TestHitClock(t *testing.T) {
+	ts := time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(ts)
+	c := New(Clock(clock.Now), Location(time.UTC), Salt("salt"))
+	r := browserRequest("GET", "/")
+	a := c.hit(r, false)
+	if !a.Timestamp.Equal(ts) {
+		t.Error(a.Timestamp)
+	}
+	// Same visitor within the same day has the same session
+	clock.Add(30 * time.Minute)
+	if b := c.hit(r, false); b.Session != a.Session {
+		t.Error(a, b)
+	}
+	// Next day the session changes
+	clock.Add(time.Hour)
+	if b := c.hit(r, false); b.Session == a.Session || !b.Timestamp.Equal(ts.Add(90*time.Minute)) {
+		t.Error(a, b)
+	}
+}
+
+func TestIPAddr(t *testing.T) {
+	var proxies []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "::1/128"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		proxies = append(proxies, n)
+	}
+	for _, test := range []struct {
+		remote  string
+		headers map[string]string
+		ip      string
+	}{
+		// Headers from untrusted clients are ignored
+		{"1.2.3.4:80", nil, "1.2.3.4"},
+		{"1.2.3.4:80", map[string]string{"X-Real-IP": "5.6.7.8"}, "1.2.3.4"},
+		{"1.2.3.4:80", map[string]string{"X-Forwarded-For": "5.6.7.8"}, "1.2.3.4"},
+		{"1.2.3.4:80", map[string]string{"Forwarded": "for=5.6.7.8"}, "1.2.3.4"},
+		// Trusted proxy without headers
+		{"10.0.0.1:80", nil, "10.0.0.1"},
+		{"10.0.0.1:80", map[string]string{"X-Real-IP": "5.6.7.8"}, "5.6.7.8"},
+		// Right-most untrusted hop is used, spoofed values on the left are ignored
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "6.6.6.6, 5.6.7.8, 10.0.0.2"}, "5.6.7.8"},
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "garbage, 5.6.7.8:1234"}, "5.6.7.8"},
+		{"10.0.0.1:80", map[string]string{"X-Forwarded-For": "5.6.7.8, garbage"}, "10.0.0.1"},
+		// Forwarded header takes precedence
+		{"[::1]:80", map[string]string{
+			"Forwarded":       `for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=http;by=10.0.0.5, for=10.0.0.2`,
+			"X-Forwarded-For": "5.6.7.8",
+		}, "2001:db8:cafe::17"},
+		{"[::1]:80", map[string]string{"Forwarded": "For=5.6.7.8"}, "5.6.7.8"},
+		{"[::1]:80", map[string]string{"Forwarded": "for=unknown"}, "::1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		if ip := ipaddr(r, proxies); ip != test.ip {
+			t.Error(test, ip)
+		}
+	}
+}
+
+func TestIsBot(t *testing.T) {
+	for _, ua := range []string{
+		"",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/88.0.4324.150 Safari/537.36",
+		"Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)",
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+		"Mozilla/5.0 (compatible; SemrushBot/7~bl; +http://www.semrush.com/bot.html)",
+		"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+		"curl/7.68.0",
+		"python-requests/2.25.1",
+		"Go-http-client/1.1",
+	} {
+		if !isBot(ua) {
+			t.Error(ua)
+		}
+	}
+	for _, ua := range []string{
+		firefox,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 14_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.3 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.150 Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 10; SM-G975F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.181 Mobile Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.152 YaBrowser/21.2.1.94.00 SA/3 Mobile Safari/537.36",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:85.0) Gecko/20100101 Firefox/85.0 ResearchSearch/1.0",
+	} {
+		if isBot(ua) {
+			t.Error(ua)
+		}
+	}
+}
+
+type ipSet map[string]bool
+
+func (s ipSet) Contains(ip string) bool { return s[ip] }
+
+func TestHitBot(t *testing.T) {
+	c := New(Dir(t.TempDir()), Datacenters(ipSet{"5.6.7.8": true}))
+	r := browserRequest("GET", "/")
+	r.RemoteAddr = "1.2.3.4:1234"
+	if hit := c.hit(r, false); hit.Device == Bot {
+		t.Error(hit)
+	}
+	// Datacenter IP address
+	r.RemoteAddr = "5.6.7.8:1234"
+	if hit := c.hit(r, false); hit.Device != Bot || hit.URI != "" || hit.Session != "" {
+		t.Error(hit)
+	}
+	// Missing Accept-Language
+	r.RemoteAddr = "1.2.3.4:1234"
+	r.Header.Del("Accept-Language")
+	if hit := c.hit(r, false); hit.Device != Bot {
+		t.Error(hit)
+	}
+	// Missing User-Agent
+	r = browserRequest("GET", "/")
+	r.Header.Del("User-Agent")
+	if hit := c.hit(r, false); hit.Device != Bot {
+		t.Error(hit)
+	}
+}
+
+func TestHitBotHeuristics(t *testing.T) {
+	c := New(Dir(t.TempDir()), BotHeuristics(false, false), Datacenters(ipSet{"5.6.7.8": true}))
+	r := browserRequest("GET", "/")
+	r.Header.Del("User-Agent")
+	r.Header.Del("Accept-Language")
+	if hit := c.hit(r, false); hit.Device == Bot {
+		t.Error(hit)
+	}
+	// Other checks still apply
+	r.RemoteAddr = "5.6.7.8:1234"
+	if hit := c.hit(r, false); hit.Device != Bot {
+		t.Error(hit)
+	}
+	r = browserRequest("GET", "/")
+	r.Header.Set("User-Agent", "Googlebot/2.1")
+	if hit := c.hit(r, false); hit.Device != Bot {
+		t.Error(hit)
+	}
+}
+
+func TestHitKind(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	for _, test := range []struct {
+		target string
+		kind   string
+		uri    string
+	}{
+		{"/null.gif?u=https://example.com/a", "", "/a"},
+		{"/null.gif?u=https://example.com/a&k=outbound&l=https://github.com/nullitics?tab=1", Outbound, "github.com/nullitics"},
+		{"/null.gif?u=https://example.com/a&k=download&l=https://example.com/files/a.pdf", Download, "/files/a.pdf"},
+		{"/null.gif?u=https://example.com/a&k=download&l=https://cdn.example.com/a.zip", Download, "cdn.example.com/a.zip"},
+		{"/null.gif?u=https://example.com/a&k=unknown&l=https://github.com/", "", "/a"},
+	} {
+		if hit := c.hit(browserRequest("GET", test.target), true); hit.Kind != test.kind || hit.URI != test.uri {
+			t.Error(test, hit)
+		}
+	}
+}
+
+func TestHitEvent(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	hit := c.hit(browserRequest("GET", "/null.gif?u=https://example.com/a&k=event&e=sign,up"), true)
+	if hit.Kind != Event || hit.URI != "sign%2Cup" {
+		t.Error(hit)
+	}
+}
@@ -0,0 +1,110 @@
+package nullitics
+
+import (
+	"sort"
+	"strings"
+)
+
+// Other is the name of the row that collects the values folded away by the
+// cardinality limits. In the goal breakdown frames it is prefixed with the
+// goal name, i.e. "Signup|(other)".
+const Other = "(other)"
+
+// Limits are the cardinality limits of a stats frame. Zero values mean no
+// limit.
+type Limits struct {
+	// Daily is the maximum number of rows in the daily stats. Rows with the
+	// lowest totals are folded into the Other row.
+	Daily int
+	// History is the maximum number of rows in the historical stats. New values
+	// are counted in the Other row once the limit is reached.
+	History int
+	// Prune is the minimum total for the historical rows. Rows below it are
+	// folded into the Other row at the end of each day.
+	Prune int
+}
+
+// DefaultLimits are the cardinality limits of the frames that have no limits
+// set with the Limit option.
+var DefaultLimits = Limits{Daily: 1000, History: 10000}
+
+// otherRow returns the name of the row where the given row is folded into.
+func otherRow(frame, name string) string {
+	switch frame {
+	case "GoalRefs", "GoalCountries", "GoalDevices":
+		if i := strings.LastIndex(name, goalSep); i >= 0 {
+			return name[:i+len(goalSep)] + Other
+		}
+	}
+	return Other
+}
+
+// isOther returns true if the row collects the folded values.
+func isOther(name string) bool {
+	return name == Other || strings.HasSuffix(name, goalSep+Other)
+}
+
+// fold keeps at most limit rows with the highest totals in the frame and adds
+// the rest into the Other rows.
+func (f *Frame) fold(limit int, other func(string) string) {
+	if limit <= 0 || len(f.Rows) <= limit {
+		return
+	}
+	type ranked struct {
+		name  string
+		total int
+	}
+	rows := []ranked{}
+	for _, row := range f.Rows {
+		if !isOther(row.Name) {
+			rows = append(rows, ranked{row.Name, row.Last(len(row.Values))})
+		}
+	}
+	if len(rows) <= limit {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].total > rows[j].total })
+	for _, r := range rows[limit:] {
+		f.merge(r.name, other(r.name))
+	}
+}
+
+// prune adds the rows with totals below min into the Other rows.
+func (f *Frame) prune(min int, other func(string) string) {
+	if min <= 0 {
+		return
+	}
+	names := []string{}
+	for _, row := range f.Rows {
+		if !isOther(row.Name) && row.Last(len(row.Values)) < min {
+			names = append(names, row.Name)
+		}
+	}
+	for _, name := range names {
+		f.merge(name, other(name))
+	}
+}
+
+// merge adds the values of the row into another row and deletes it.
+func (f *Frame) merge(name, into string) {
+	i, found := f.find(name)
+	if !found {
+		return
+	}
+	values := f.Rows[i].Values
+	f.Delete(name)
+	dst := f.Row(into).Values
+	for j, v := range values {
+		if j < len(dst) {
+			dst[j] += v
+		}
+	}
+}
+
+// frameLimits returns the cardinality limits of the frame.
+func (c *Collector) frameLimits(frame string) Limits {
+	if l, ok := c.limits[frame]; ok {
+		return l
+	}
+	return DefaultLimits
+}
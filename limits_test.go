@@ -0,0 +1,113 @@
+package nullitics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFrameFold(t *testing.T) {
+	f := &Frame{len: 2}
+	for name, values := range map[string][]int{"a": {5, 5}, "b": {1, 0}, "c": {3, 0}, "d": {0, 2}, Other: {1, 1}} {
+		copy(f.Row(name).Values, values)
+	}
+	f.fold(2, func(string) string { return Other })
+	if len(f.Rows) != 3 || f.Rows[0].Name != Other || f.Rows[1].Name != "a" || f.Rows[2].Name != "c" {
+		t.Fatal(f.Rows)
+	}
+	if v := f.Row(Other).Values; v[0] != 2 || v[1] != 3 {
+		t.Error(v)
+	}
+	f.prune(4, func(string) string { return Other })
+	if len(f.Rows) != 2 || f.Row(Other).Last(2) != 8 {
+		t.Error(f.Rows)
+	}
+	// Zero limits do nothing
+	f.fold(0, nil)
+	f.prune(0, nil)
+	if len(f.Rows) != 2 {
+		t.Error(f.Rows)
+	}
+}
+
+func TestOtherRow(t *testing.T) {
+	for _, test := range [][3]string{
+		{"URIs", "/a|b", Other},
+		{"GoalRefs", "Signup|google.com", "Signup|" + Other},
+		{"GoalDevices", "Signup", Other},
+	} {
+		if s := otherRow(test[0], test[1]); s != test[2] {
+			t.Error(test, s)
+		}
+	}
+}
+
+func TestStatsFrame(t *testing.T) {
+	stats := &Stats{}
+	if stats.Frame("URIs") != &stats.URIs || stats.Frame("Funnels") != &stats.Funnels || stats.Frame("Foo") != nil {
+		t.Error("wrong frame")
+	}
+	if len(frameNames) != len(stats.frames()) {
+		t.Error(frameNames)
+	}
+}
+
+func TestCollectorLimits(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now),
+		Goals(Goal{Name: "Signup", Path: "/signup"}),
+		Limit("URIs", Limits{Daily: 3, History: 5, Prune: 3}),
+		Limit("GoalRefs", Limits{Daily: 1}))
+	defer c.Close()
+	day := func(n int, hits ...*Hit) {
+		for _, hit := range hits {
+			hit.Timestamp = clock.Now()
+			if err := c.Hit(hit); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clock.Add(24 * time.Hour * time.Duration(n))
+	}
+	hits := []*Hit{}
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			hits = append(hits, &Hit{URI: fmt.Sprintf("/%d", i), Session: fmt.Sprint(i, j)})
+		}
+	}
+	hits = append(hits,
+		&Hit{URI: "/signup", Session: "x", Ref: "a.com"}, &Hit{URI: "/signup", Session: "y", Ref: "b.com"},
+		&Hit{URI: "/signup", Session: "z", Ref: "b.com"})
+	day(1, hits...)
+	daily, _, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Top 3 daily URIs are kept: /4 (5), /3 (4), /2 (3)
+	if len(daily.URIs.Rows) != 4 || daily.URIs.Row(Other).Last(24) != 6 || daily.URIs.Row("/4").Last(24) != 5 {
+		t.Error(daily.URIs.Rows)
+	}
+	if daily.GoalRefs.Row("Signup|b.com").Last(24) != 2 || daily.GoalRefs.Row("Signup|"+Other).Last(24) != 1 {
+		t.Error(daily.GoalRefs.Rows)
+	}
+	// Repeated merges of the same day do not double count
+	_, history, _ := c.Stats()
+	_, history, _ = c.Stats()
+	if n := history.URIs.Row(Other).Last(1); n != 6 {
+		t.Error(n)
+	}
+	// New values go into Other once history is full
+	day(1, &Hit{URI: "/new", Session: "n"}, &Hit{URI: "/new", Session: "m"}, &Hit{URI: "/zzz", Session: "o"})
+	_, history, _ = c.Stats()
+	if _, found := history.URIs.find("/zzz"); found || history.URIs.Row("/new").Last(1) != 2 || history.URIs.Row(Other).Last(1) != 1 {
+		t.Error(history.URIs.Rows)
+	}
+	// Rows below the prune threshold are folded at rollover
+	day(1, &Hit{URI: "/4", Session: "p"})
+	_, history, _ = c.Stats()
+	if _, found := history.URIs.find("/new"); found || len(history.URIs.Rows) != 4 {
+		t.Error(history.URIs.Rows)
+	}
+	if n := history.URIs.Row(Other).Last(3); n != 9 {
+		t.Error(n)
+	}
+}
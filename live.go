@@ -0,0 +1,119 @@
+package nullitics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var (
+	// LiveWindow is the period of time the visitors are considered to be
+	// currently on the site.
+	LiveWindow = 5 * time.Minute
+	// LiveInterval is how often the live stats are sent to the clients.
+	LiveInterval = 5 * time.Second
+	// LiveTop is the maximum number of pages in the live stats.
+	LiveTop = 10
+)
+
+// LiveStats are the visitors that viewed the site within the LiveWindow.
+type LiveStats struct {
+	Visitors int
+	Pages    []LivePage
+}
+
+// LivePage is the number of current visitors on a page.
+type LivePage struct {
+	URI      string
+	Visitors int
+}
+
+// recentHits keeps the latest page view of each session within the
+// LiveWindow in memory.
+type recentHits struct {
+	visits  map[string]*Hit
+	expired time.Time
+}
+
+// add records the page view, the old ones are dropped at most once a second.
+func (r *recentHits) add(hit *Hit) {
+	if hit.Kind != "" || hit.Device == Bot {
+		return
+	}
+	if r.visits == nil {
+		r.visits = map[string]*Hit{}
+	}
+	r.visits[hit.Session] = hit
+	if hit.Timestamp.Sub(r.expired) > time.Second {
+		r.expire(hit.Timestamp)
+	}
+}
+
+// expire drops the page views that are older than LiveWindow at the given
+// time.
+func (r *recentHits) expire(now time.Time) {
+	for session, hit := range r.visits {
+		if now.Sub(hit.Timestamp) >= LiveWindow {
+			delete(r.visits, session)
+		}
+	}
+	r.expired = now
+}
+
+// stats returns the number of current visitors and their latest pages.
+func (r *recentHits) stats() *LiveStats {
+	counts := map[string]int{}
+	for _, hit := range r.visits {
+		counts[hit.URI]++
+	}
+	live := &LiveStats{Visitors: len(r.visits), Pages: []LivePage{}}
+	for uri, n := range counts {
+		live.Pages = append(live.Pages, LivePage{URI: uri, Visitors: n})
+	}
+	sort.Slice(live.Pages, func(i, j int) bool {
+		a, b := live.Pages[i], live.Pages[j]
+		return a.Visitors > b.Visitors || (a.Visitors == b.Visitors && a.URI < b.URI)
+	})
+	if len(live.Pages) > LiveTop {
+		live.Pages = live.Pages[:LiveTop]
+	}
+	return live
+}
+
+// LiveStats returns the visitors that viewed the site within the LiveWindow.
+func (c *Collector) LiveStats() *LiveStats {
+	c.Lock()
+	defer c.Unlock()
+	c.recent.expire(c.now())
+	return c.recent.stats()
+}
+
+// Live returns a handler that streams LiveStats as Server-Sent Events every
+// LiveInterval, until the client disconnects.
+func (c *Collector) Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		ticker := time.NewTicker(LiveInterval)
+		defer ticker.Stop()
+		for {
+			b, _ := json.Marshal(c.LiveStats())
+			if _, err := w.Write([]byte("data: " + string(b) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package nullitics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveStats(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	for _, hit := range []*Hit{
+		{URI: "/", Session: "a"},
+		{URI: "/about", Session: "a"},
+		{URI: "/", Session: "b"},
+		{URI: "/", Session: "c", Device: Bot},
+		{URI: "play", Session: "b", Kind: Event},
+	} {
+		hit.Timestamp = clock.Now()
+		if err := c.Hit(hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Add(4 * time.Minute)
+	if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/about", Session: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	live := c.LiveStats()
+	if !reflect.DeepEqual(live, &LiveStats{Visitors: 3, Pages: []LivePage{{"/about", 2}, {"/", 1}}}) {
+		t.Error(live)
+	}
+	// Older visitors leave the site
+	clock.Add(2 * time.Minute)
+	if live := c.LiveStats(); live.Visitors != 1 || len(live.Pages) != 1 {
+		t.Error(live)
+	}
+	clock.Add(5 * time.Minute)
+	if live := c.LiveStats(); live.Visitors != 0 || live.Pages == nil {
+		t.Error(live)
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	defer c.Close()
+	if err := c.Hit(&Hit{Timestamp: time.Now(), URI: "/", Session: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(c.Report(nil))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest("GET", srv.URL+"/?live", nil).WithContext(ctx)
+	req.RequestURI = ""
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Error(ct)
+	}
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: ") {
+		t.Fatal(line, err)
+	}
+	live := &LiveStats{}
+	if err := json.Unmarshal([]byte(line[len("data: "):]), live); err != nil || live.Visitors != 1 {
+		t.Error(live, err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package nullitics
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is not supported on this platform, so the shared storage mode can
+// not be used.
+func lockFile(f *os.File) error {
+	return errors.New("file locks are not supported on this platform")
+}
+
+// unlockFile releases the file lock.
+func unlockFile(f *os.File) error { return nil }
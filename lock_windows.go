@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package nullitics
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+// lockFile acquires an exclusive lock on the file, waiting until it is
+// released by other processes.
+func lockFile(f *os.File) error {
+	ol := &syscall.Overlapped{}
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases the file lock.
+func unlockFile(f *os.File) error {
+	ol := &syscall.Overlapped{}
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package nullitics
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"os"
 	"path/filepath"
@@ -12,9 +13,10 @@ import (
 
 // Appender is an append-only log writer.
 type Appender struct {
-	f     *os.File
-	start time.Time
-	sb    strings.Builder
+	f       *os.File
+	start   time.Time
+	pending time.Time
+	buf     bytes.Buffer
 }
 
 // NewAppender creates an Appender for the given log filename. It may
@@ -31,12 +33,28 @@ func NewAppender(filename string, truncate bool) (*Appender, error) {
 	}
 
 	// Read timestamp, if any. Start time is zero if the log file is empty
+	start, err := readLogStart(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Jump to the end of the file for appending
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+
+	return &Appender{f: f, start: start}, nil
+}
+
+// readLogStart returns the timestamp of the first hit in the log, or zero time
+// if the log is empty.
+func readLogStart(r io.Reader) (time.Time, error) {
 	buf := make([]byte, 64)
-	n, err := f.Read(buf)
+	n, err := r.Read(buf)
 	if n == 0 && err == io.EOF {
-		return &Appender{f: f}, nil
+		return time.Time{}, nil
 	} else if err != nil {
-		return nil, err
+		return time.Time{}, err
 	}
 	unix := int64(0)
 	for i := 0; i < n; i++ {
@@ -46,64 +64,123 @@ func NewAppender(filename string, truncate bool) (*Appender, error) {
 			break
 		}
 	}
+	return time.Unix(unix, 0), nil
+}
 
-	// Jump to the end of the file for appending
-	if _, err := f.Seek(0, io.SeekEnd); err != nil {
-		return nil, err
+// StartTime returns the timestamp of the first hit in the log, including the
+// buffered ones.
+func (ap *Appender) StartTime() time.Time {
+	if ap.start.IsZero() {
+		return ap.pending
 	}
-
-	return &Appender{f: f, start: time.Unix(unix, 0)}, nil
+	return ap.start
 }
 
-// StartTime returns the timestamp of the first hit in the log.
-func (ap *Appender) StartTime() time.Time { return ap.start }
-
-// Close shuts down the appender.
-func (ap *Appender) Close() error { return ap.f.Close() }
+// Close writes the buffered hits and shuts down the appender.
+func (ap *Appender) Close() error {
+	err := ap.Flush()
+	if cerr := ap.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
 
 // Append write hit data to the end of the log file.
 func (ap *Appender) Append(hit *Hit) error {
-	ap.sb.Reset()
-	ap.sb.WriteString(strconv.FormatInt(hit.Timestamp.Unix(), 10))
-	ap.sb.WriteByte(',')
-	ap.sb.WriteString(hit.URI)
-	ap.sb.WriteByte(',')
-	ap.sb.WriteString(hit.Session)
-	ap.sb.WriteByte(',')
-	ap.sb.WriteString(hit.Ref)
-	ap.sb.WriteByte(',')
-	ap.sb.WriteString(hit.Country)
-	ap.sb.WriteByte(',')
-	ap.sb.WriteString(hit.Device)
-	ap.sb.WriteByte('\n')
-	_, err := ap.f.Write([]byte(ap.sb.String()))
+	ap.Buffer(hit)
+	return ap.Flush()
+}
+
+// logReplacer escapes characters that have a special meaning in the log file,
+// so that a comma in i.e. a page path can not shift the following columns.
+var logReplacer = strings.NewReplacer(",", "%2C", "\n", "%0A", "\r", "%0D")
+
+// Buffer adds hit data to the buffer, which is written to the log file by
+// Flush at once. Commas and line breaks in the hit fields are escaped.
+func (ap *Appender) Buffer(hit *Hit) {
+	if ap.start.IsZero() && ap.pending.IsZero() {
+		ap.pending = hit.Timestamp
+	}
+	ap.buf.WriteString(strconv.FormatInt(hit.Timestamp.Unix(), 10))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.URI))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.Session))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.Ref))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.Country))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.Device))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.Channel))
+	ap.buf.WriteByte(',')
+	ap.buf.WriteString(logReplacer.Replace(hit.Kind))
+	ap.buf.WriteByte('\n')
+}
+
+// Flush writes the buffered hits to the end of the log file. Buffer is
+// cleared even if the write fails.
+func (ap *Appender) Flush() error {
+	if ap.buf.Len() == 0 {
+		return nil
+	}
+	_, err := ap.f.Write(ap.buf.Bytes())
+	ap.buf.Reset()
 	if err == nil && ap.start.IsZero() {
-		ap.start = hit.Timestamp
+		ap.start = ap.pending
 	}
+	ap.pending = time.Time{}
 	return err
 }
 
 // ParseAppendLog read the log file, assuming the timestamps are in the given
 // time zone, and returns a Stats object with hourly precision.
 func ParseAppendLog(filename string, location *time.Location) (*Stats, error) {
+	return parseAppendLog([]string{filename}, location, nil, nil)
+}
+
+// parseAppendLog reads the log files (i.e. shards of the same day) like
+// ParseAppendLog, and also counts the sessions converted into the given goals
+// and the funnel steps they reached.
+func parseAppendLog(filenames []string, location *time.Location, goals []Goal, funnels []Funnel) (*Stats, error) {
 	stats := &Stats{
-		Interval:  time.Hour,
-		URIs:      Frame{len: 24},
-		Sessions:  Frame{len: 24},
-		Refs:      Frame{len: 24},
-		Countries: Frame{len: 24},
-		Devices:   Frame{len: 24},
+		Interval:      time.Hour,
+		URIs:          Frame{len: 24},
+		Sessions:      Frame{len: 24},
+		Refs:          Frame{len: 24},
+		Countries:     Frame{len: 24},
+		Devices:       Frame{len: 24},
+		Bots:          Frame{len: 24},
+		Channels:      Frame{len: 24},
+		Outbound:      Frame{len: 24},
+		Downloads:     Frame{len: 24},
+		Goals:         Frame{len: 24},
+		GoalRefs:      Frame{len: 24},
+		GoalCountries: Frame{len: 24},
+		GoalDevices:   Frame{len: 24},
+		Funnels:       Frame{len: 24},
 	}
-	f, err := os.Open(filename)
-	if err != nil {
+	// Funnels always have all their steps, even if nobody reached them
+	for i := range funnels {
+		for n := range funnels[i].Steps {
+			stats.Funnels.Row(funnelStep(&funnels[i], n))
+		}
+	}
+	// Shards are read as a single log, so that sessions are counted once
+	readers := []io.Reader{}
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
 		if os.IsNotExist(err) {
-			return stats, nil
+			continue
+		} else if err != nil {
+			return nil, err
 		}
-		return nil, err
+		defer f.Close()
+		readers = append(readers, f, strings.NewReader("\n"))
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	sessions := map[string]bool{}
+	r := bufio.NewReader(io.MultiReader(readers...))
+	visits := map[string]*visit{}
 	for {
 		line, err := r.ReadString('\n')
 		if err != nil {
@@ -115,8 +192,9 @@ func ParseAppendLog(filename string, location *time.Location) (*Stats, error) {
 		if len(line) > 0 && line[len(line)-1] == '\n' {
 			line = line[:len(line)-1]
 		}
+		// Older logs may have fewer columns
 		parts := strings.Split(line, ",")
-		if len(parts) != 6 {
+		if len(parts) < 6 {
 			continue
 		}
 		unix, err := strconv.ParseInt(parts[0], 10, 64)
@@ -128,22 +206,57 @@ func ParseAppendLog(filename string, location *time.Location) (*Stats, error) {
 			stats.Start = date(timestamp)
 		}
 		hour := timestamp.Hour()
-		if uri := parts[1]; uri != "" {
-			stats.URIs.Row(uri).Values[hour]++
+		uri, sess, ref, cn, dev, kind := parts[1], parts[2], parts[3], parts[4], parts[5], ""
+		if len(parts) > 7 {
+			kind = parts[7]
 		}
-		if sess := parts[2]; sess == "" || !sessions[sess] {
-			sessions[sess] = true
-			stats.Sessions.Row("sessions").Values[hour]++
-			if ref := parts[3]; ref != "" {
-				stats.Refs.Row(ref).Values[hour]++
-			}
-			if cn := parts[4]; cn != "" {
-				stats.Countries.Row(cn).Values[hour]++
+		if dev == Bot {
+			stats.Bots.Row("bots").Values[hour]++
+			continue
+		}
+		switch kind {
+		case Outbound:
+			stats.Outbound.Row(uri).Values[hour]++
+			continue
+		case Download:
+			stats.Downloads.Row(uri).Values[hour]++
+			continue
+		case "", Event:
+		default:
+			continue
+		}
+		// Hits without a session are treated as separate sessions
+		v := visits[sess]
+		if v == nil || sess == "" {
+			v = &visit{ref: ref, country: cn, device: dev}
+			visits[sess] = v
+		}
+		if kind == "" {
+			if uri != "" {
+				stats.URIs.Row(uri).Values[hour]++
 			}
-			if dev := parts[5]; dev != "" {
-				stats.Devices.Row(dev).Values[hour]++
+			if !v.viewed {
+				v.viewed, v.ref, v.country, v.device = true, ref, cn, dev
+				stats.Sessions.Row("sessions").Values[hour]++
+				if ref != "" {
+					stats.Refs.Row(ref).Values[hour]++
+				}
+				if cn != "" {
+					stats.Countries.Row(cn).Values[hour]++
+				}
+				if dev != "" {
+					stats.Devices.Row(dev).Values[hour]++
+				}
+				if len(parts) > 6 && parts[6] != "" {
+					stats.Channels.Row(parts[6]).Values[hour]++
+				} else if sess != "" {
+					_, ch := DefaultRefRules.Find(ref)
+					stats.Channels.Row(ch).Values[hour]++
+				}
 			}
 		}
+		v.convert(stats, goals, kind, uri, hour)
+		v.advance(stats, funnels, kind, uri, hour)
 	}
 	return stats, nil
 }
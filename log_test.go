@@ -3,6 +3,7 @@ package nullitics
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -58,7 +59,7 @@ func TestAppender(t *testing.T) {
 			t.Error(err)
 		}
 		b, _ := ioutil.ReadFile(testFile)
-		if string(b) != "123456789,/foo,,,,\n123456790,/hello,,,,\n" {
+		if string(b) != "123456789,/foo,,,,,,\n123456790,/hello,,,,,,\n" {
 			t.Error(string(b))
 		}
 	})
@@ -89,16 +90,16 @@ func TestLogStats(t *testing.T) {
 		return t
 	}
 	for _, hit := range []*Hit{
-		{ts("2021-01-01 10:30"), "/a", "", "", "", ""},
-		{ts("2021-01-01 10:42"), "/b", "", "", "", ""},
-		{ts("2021-01-01 12:00"), "/c", "", "", "", ""},
-		{ts("2021-01-01 18:00"), "/c", "", "", "", ""},
-		{ts("2021-01-01 18:01"), "/c", "", "", "", ""},
-		{ts("2021-01-01 18:59"), "/d", "", "", "", ""},
-		{ts("2021-01-02 00:00"), "/e", "", "", "", ""},
-		{ts("2021-01-02 08:00"), "/f", "", "", "", ""},
-		{ts("2021-01-04 09:45"), "/g", "", "", "", ""},
-		{ts("2021-01-05 07:30"), "/h", "", "", "", ""},
+		{Timestamp: ts("2021-01-01 10:30"), URI: "/a"},
+		{Timestamp: ts("2021-01-01 10:42"), URI: "/b"},
+		{Timestamp: ts("2021-01-01 12:00"), URI: "/c"},
+		{Timestamp: ts("2021-01-01 18:00"), URI: "/c"},
+		{Timestamp: ts("2021-01-01 18:01"), URI: "/c"},
+		{Timestamp: ts("2021-01-01 18:59"), URI: "/d"},
+		{Timestamp: ts("2021-01-02 00:00"), URI: "/e"},
+		{Timestamp: ts("2021-01-02 08:00"), URI: "/f"},
+		{Timestamp: ts("2021-01-04 09:45"), URI: "/g"},
+		{Timestamp: ts("2021-01-05 07:30"), URI: "/h"},
 	} {
 		if err := c.Hit(hit); err != nil {
 			t.Error(err)
@@ -108,10 +109,10 @@ func TestLogStats(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if err := c.Hit(&Hit{ts("2021-01-05 00:01"), "/g", "", "", "", ""}); err != nil {
+	if err := c.Hit(&Hit{Timestamp: ts("2021-01-05 00:01"), URI: "/g"}); err != nil {
 		t.Error(err)
 	}
-	if err := c.Hit(&Hit{ts("2021-01-05 23:59"), "/h", "", "", "", ""}); err != nil {
+	if err := c.Hit(&Hit{Timestamp: ts("2021-01-05 23:59"), URI: "/h"}); err != nil {
 		t.Error(err)
 	}
 	d2, h2, err := c.Stats()
@@ -125,3 +126,103 @@ func TestLogStats(t *testing.T) {
 	//t.Log(h2)
 	_, _, _, _ = d, d2, h, h2
 }
+
+// Ensure that separators and newlines in the fields do not break the log lines
+func TestLogEscape(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), dailyLog)
+	ap, err := NewAppender(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	if err := ap.Append(&Hit{Timestamp: ts, URI: "/a,b\nc", Session: "a", Ref: "x,y", Country: "DE", Device: Mobile}); err != nil {
+		t.Fatal(err)
+	} else if err := ap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ParseAppendLog(filename, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stats.URIs.Row("/a%2Cb%0Ac").Last(24); n != 1 {
+		t.Error(stats.URIs.Rows)
+	}
+	if n := stats.Refs.Row("x%2Cy").Last(24); n != 1 {
+		t.Error(stats.Refs.Rows)
+	}
+	if n := stats.Countries.Row("DE").Last(24); n != 1 {
+		t.Error(stats.Countries.Rows)
+	}
+	if n := stats.Devices.Row(Mobile).Last(24); n != 1 {
+		t.Error(stats.Devices.Rows)
+	}
+}
+
+// Ensure that logs with legacy 4-byte session hashes are still readable
+func TestLegacySessions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), dailyLog)
+	log := "1609495200,/a,1a2b3c4d,,DE,desktop\n" +
+		"1609495260,/b,1a2b3c4d,,DE,desktop\n" +
+		"1609498800,/a,0123456789abcdef,,FR,mobile\n" +
+		"1609498860,/c,0123456789abcdef,,FR,mobile\n"
+	if err := ioutil.WriteFile(filename, []byte(log), 0666); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ParseAppendLog(filename, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stats.Sessions.Row("sessions").Last(24); n != 2 {
+		t.Error(n)
+	}
+	if n := stats.URIs.Row("/a").Last(24); n != 2 {
+		t.Error(n)
+	}
+	// Channels are derived from referrers for older logs
+	if n := stats.Channels.Row(ChannelDirect).Last(24); n != 2 {
+		t.Error(n)
+	}
+}
+
+// Ensure that bots, outbound links and downloads are counted separately and do
+// not affect sessions
+func TestHitKindStats(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), dailyLog)
+	ap, err := NewAppender(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, hit := range []*Hit{
+		{Timestamp: ts, URI: "/", Session: "a", Device: Desktop},
+		{Timestamp: ts, Device: Bot},
+		{Timestamp: ts.Add(time.Hour), Device: Bot},
+		{Timestamp: ts, URI: "github.com/", Session: "b", Device: Desktop, Kind: Outbound},
+		{Timestamp: ts, URI: "/a.pdf", Session: "a", Device: Desktop, Kind: Download},
+	} {
+		if err := ap.Append(hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ap.Close()
+	stats, err := ParseAppendLog(filename, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := stats.Sessions.Row("sessions").Last(24); n != 1 {
+		t.Error(n)
+	}
+	if v := stats.Bots.Row("bots").Values; v[10] != 1 || v[11] != 1 {
+		t.Error(v)
+	}
+	// Outbound links and downloads are neither page views nor sessions
+	if len(stats.URIs.Rows) != 1 {
+		t.Error(stats.URIs.Rows)
+	}
+	if n := stats.Outbound.Row("github.com/").Last(24); n != 1 {
+		t.Error(n)
+	}
+	if n := stats.Downloads.Row("/a.pdf").Last(24); n != 1 {
+		t.Error(n)
+	}
+}
@@ -0,0 +1,131 @@
+package nullitics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MaxMetricLabels is the number of distinct devices or countries exported as
+// separate metrics, the rest are counted with the "(other)" label.
+var MaxMetricLabels = 300
+
+// maxMetricSessions limits the number of sessions remembered to count them
+// once a day. Beyond it, the new sessions are counted on each page view.
+const maxMetricSessions = 100000
+
+// metrics keeps the collector counters since the process start.
+type metrics struct {
+	hits      uint64
+	bots      uint64
+	errors    uint64
+	sessions  uint64
+	devices   labelCounter
+	countries labelCounter
+	rollovers uint64
+	rollover  time.Duration
+	day       time.Time
+	seen      map[string]bool
+}
+
+// labelCounter counts hits per label value with a limited cardinality.
+type labelCounter map[string]uint64
+
+func (lc labelCounter) inc(label string) {
+	if _, ok := lc[label]; !ok && len(lc) >= MaxMetricLabels {
+		label = Other
+	}
+	lc[label]++
+}
+
+// add counts the hit, sessions are counted once a day on their first page
+// view, like in the stats.
+func (m *metrics) add(hit *Hit, day time.Time) {
+	m.hits++
+	if hit.Device == Bot {
+		m.bots++
+		return
+	}
+	if !day.Equal(m.day) || m.seen == nil {
+		m.day, m.seen = day, map[string]bool{}
+	}
+	if hit.Kind != "" || (hit.Session != "" && m.seen[hit.Session]) {
+		return
+	}
+	if hit.Session != "" && len(m.seen) < maxMetricSessions {
+		m.seen[hit.Session] = true
+	}
+	m.sessions++
+	if m.devices == nil {
+		m.devices, m.countries = labelCounter{}, labelCounter{}
+	}
+	if hit.Device != "" {
+		m.devices.inc(hit.Device)
+	}
+	if hit.Country != "" {
+		m.countries.inc(hit.Country)
+	}
+}
+
+// write prints the metrics in Prometheus text exposition format.
+func (m *metrics) write(w *bytes.Buffer, statsSize int64, dropped uint64, queued int) {
+	counter := func(name, help string, n uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, n)
+	}
+	labels := func(name, help, label string, lc labelCounter) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		keys := make([]string, 0, len(lc))
+		for k := range lc {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(k), lc[k])
+		}
+	}
+	counter("nullitics_hits_total", "Total number of recorded hits.", m.hits)
+	counter("nullitics_sessions_total", "Total number of sessions.", m.sessions)
+	labels("nullitics_device_sessions_total", "Number of sessions per device type.", "device", m.devices)
+	labels("nullitics_country_sessions_total", "Number of sessions per country.", "country", m.countries)
+	counter("nullitics_bot_hits_total", "Total number of hits from bots and crawlers excluded from the stats.", m.bots)
+	counter("nullitics_write_errors_total", "Total number of hits that failed to be written to the log.", m.errors)
+	counter("nullitics_dropped_hits_total", "Total number of hits dropped because the asynchronous queue was full.", dropped)
+	fmt.Fprintf(w, "# HELP nullitics_queued_hits Number of hits waiting in the asynchronous queue.\n")
+	fmt.Fprintf(w, "# TYPE nullitics_queued_hits gauge\n")
+	fmt.Fprintf(w, "nullitics_queued_hits %d\n", queued)
+	fmt.Fprintf(w, "# HELP nullitics_rollover_duration_seconds Time spent merging the daily log into the history.\n")
+	fmt.Fprintf(w, "# TYPE nullitics_rollover_duration_seconds summary\n")
+	fmt.Fprintf(w, "nullitics_rollover_duration_seconds_sum %g\n", m.rollover.Seconds())
+	fmt.Fprintf(w, "nullitics_rollover_duration_seconds_count %d\n", m.rollovers)
+	fmt.Fprintf(w, "# HELP nullitics_stats_size_bytes Size of the history stats file.\n")
+	fmt.Fprintf(w, "# TYPE nullitics_stats_size_bytes gauge\n")
+	fmt.Fprintf(w, "nullitics_stats_size_bytes %d\n", statsSize)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Metrics returns an HTTP handler that exposes the collector counters in
+// Prometheus text format. The counters include per-country traffic, so it
+// should be put behind authentication.
+func (c *Collector) Metrics() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var size int64
+		if fi, err := os.Stat(filepath.Join(c.dir, historyLog)); err == nil {
+			size = fi.Size()
+		}
+		b := &bytes.Buffer{}
+		c.Lock()
+		c.metrics.write(b, size, atomic.LoadUint64(&c.dropped), len(c.queue))
+		c.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(b.Bytes())
+	})
+}
@@ -0,0 +1,90 @@
+package nullitics
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	hits := []*Hit{
+		{URI: "/", Session: "a", Country: "DE", Device: Desktop},
+		{URI: "/about", Session: "a", Country: "DE", Device: Desktop},
+		{URI: "/", Session: "b", Country: "FR", Device: Mobile},
+		{URI: "play", Session: "b", Kind: Event},
+		{Device: Bot},
+	}
+	for _, hit := range hits {
+		hit.Timestamp = clock.Now()
+		if err := c.Hit(hit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The same session is counted again on the next day
+	clock.Add(24 * time.Hour)
+	if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "a", Country: "DE", Device: Desktop}); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.Metrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Error(ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE nullitics_hits_total counter",
+		"nullitics_hits_total 6",
+		"nullitics_sessions_total 3",
+		`nullitics_device_sessions_total{device="desktop"} 2`,
+		`nullitics_device_sessions_total{device="mobile"} 1`,
+		`nullitics_country_sessions_total{country="DE"} 2`,
+		`nullitics_country_sessions_total{country="FR"} 1`,
+		"nullitics_bot_hits_total 1",
+		"nullitics_write_errors_total 0",
+		"nullitics_dropped_hits_total 0",
+		"nullitics_rollover_duration_seconds_count 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Error(line, body)
+		}
+	}
+	if strings.Contains(body, "nullitics_stats_size_bytes 0\n") {
+		t.Error(body)
+	}
+}
+
+func TestMetricsLabels(t *testing.T) {
+	defer func(n int) { MaxMetricLabels = n }(MaxMetricLabels)
+	MaxMetricLabels = 2
+	lc := labelCounter{}
+	for _, s := range []string{"a", "b", "c", "a", "d"} {
+		lc.inc(s)
+	}
+	if len(lc) != 3 || lc["a"] != 2 || lc["b"] != 1 || lc[Other] != 2 {
+		t.Error(lc)
+	}
+	if s := escapeLabel("a\"b\\c\n"); s != `a\"b\\c\n` {
+		t.Error(s)
+	}
+}
+
+func TestMetricsSessionsLimit(t *testing.T) {
+	m := &metrics{}
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxMetricSessions+10; i++ {
+		m.add(&Hit{URI: "/", Session: strconv.Itoa(i)}, day)
+	}
+	if len(m.seen) != maxMetricSessions || m.sessions != maxMetricSessions+10 {
+		t.Error(len(m.seen), m.sessions)
+	}
+	// Sessions are forgotten on the next day
+	m.add(&Hit{URI: "/", Session: "a"}, day.AddDate(0, 0, 1))
+	if len(m.seen) != 1 {
+		t.Error(len(m.seen))
+	}
+}
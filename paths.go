@@ -0,0 +1,113 @@
+package nullitics
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// TrackingParams are the query parameters that are never kept in the
+// recorded paths, even if they are allowed with the KeepQuery option.
+var TrackingParams = []string{
+	"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content",
+	"fbclid", "gclid", "dclid", "msclkid", "yclid", "mc_cid", "mc_eid",
+}
+
+// PathRule rewrites the matching paths, so that the similar pages are grouped
+// into a single row, i.e. "/users/123" and "/users/124" become "/users/:id".
+type PathRule struct {
+	Regexp  *regexp.Regexp
+	Replace string
+}
+
+// NewPathRule creates a path rule from a pattern. Patterns starting with "^"
+// are regular expressions, and the replacement may refer to their groups as
+// "$1". Other patterns are paths where the segments starting with ":" match
+// any single path segment, like "/users/:id/posts". If replacement is empty,
+// the pattern itself is used, which is an error for regular expressions.
+func NewPathRule(pattern, replace string) (PathRule, error) {
+	if replace == "" {
+		if strings.HasPrefix(pattern, "^") {
+			return PathRule{}, errors.New("path rule " + pattern + " has no replacement")
+		}
+		replace = pattern
+	}
+	expr := pattern
+	if !strings.HasPrefix(pattern, "^") {
+		segments := strings.Split(pattern, "/")
+		for i, s := range segments {
+			if strings.HasPrefix(s, ":") && len(s) > 1 {
+				segments[i] = "[^/]+"
+			} else {
+				segments[i] = regexp.QuoteMeta(s)
+			}
+		}
+		expr = "^" + strings.Join(segments, "/") + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return PathRule{}, err
+	}
+	return PathRule{Regexp: re, Replace: replace}, nil
+}
+
+// Rewrite returns the rewritten path and true if the path matches the rule.
+func (rule PathRule) Rewrite(path string) (string, bool) {
+	if !rule.Regexp.MatchString(path) {
+		return path, false
+	}
+	return rule.Regexp.ReplaceAllString(path, rule.Replace), true
+}
+
+// paths normalises the page paths before they are recorded.
+type paths struct {
+	rules     []PathRule
+	lowercase bool
+	trim      bool
+	query     []string
+}
+
+// normalize returns the normalised path of the URL with the allowed query
+// parameters, sorted by name.
+func (p *paths) normalize(u *url.URL) string {
+	path := u.Path
+	if p.lowercase {
+		path = strings.ToLower(path)
+	}
+	if p.trim && len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+		if path == "" {
+			path = "/"
+		}
+	}
+	for _, rule := range p.rules {
+		if s, ok := rule.Rewrite(path); ok {
+			path = s
+			break
+		}
+	}
+	if len(p.query) == 0 {
+		return path
+	}
+	values, keep := u.Query(), url.Values{}
+	for _, name := range p.query {
+		if v, ok := values[name]; ok && !isTrackingParam(name) {
+			keep[name] = v
+		}
+	}
+	if len(keep) == 0 {
+		return path
+	}
+	// Encode sorts the parameters by name
+	return path + "?" + keep.Encode()
+}
+
+func isTrackingParam(name string) bool {
+	for _, p := range TrackingParams {
+		if strings.EqualFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
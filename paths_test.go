@@ -0,0 +1,77 @@
+package nullitics
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPathRule(t *testing.T) {
+	users, _ := NewPathRule("/users/:id", "")
+	posts, _ := NewPathRule("/users/:id/posts/:post", "/users/:id/posts")
+	docs, _ := NewPathRule(`^/docs/v\d+/(.*)$`, "/docs/$1")
+	for _, test := range []struct {
+		rule PathRule
+		path string
+		want string
+		ok   bool
+	}{
+		{users, "/users/123", "/users/:id", true},
+		{users, "/users/", "/users/", false},
+		{users, "/users/123/posts", "/users/123/posts", false},
+		{posts, "/users/1/posts/2", "/users/:id/posts", true},
+		{docs, "/docs/v2/intro", "/docs/intro", true},
+		{docs, "/docs/intro", "/docs/intro", false},
+	} {
+		if s, ok := test.rule.Rewrite(test.path); s != test.want || ok != test.ok {
+			t.Error(test.path, s, ok)
+		}
+	}
+	if _, err := NewPathRule("^/(", "/"); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := NewPathRule(`^/docs/v\d+/(.*)$`, ""); err == nil {
+		t.Error("expected error for regexp without replacement")
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	users, _ := NewPathRule("/users/:id", "")
+	p := &paths{rules: []PathRule{users}, lowercase: true, trim: true, query: []string{"q", "page", "utm_source"}}
+	for uri, want := range map[string]string{
+		"/":                        "/",
+		"/docs//":                  "/docs",
+		"/About/":                  "/about",
+		"/users/123/":              "/users/:id",
+		"/Users/ABC":               "/users/:id",
+		"/search?q=go&x=1":         "/search?q=go",
+		"/search?page=2&q=go":      "/search?page=2&q=go",
+		"/search?utm_source=x&y=1": "/search",
+	} {
+		u, _ := url.Parse(uri)
+		if s := p.normalize(u); s != want {
+			t.Error(uri, s, want)
+		}
+	}
+	// By default only the path is kept
+	u, _ := url.Parse("/Foo/?q=1")
+	if s := (&paths{}).normalize(u); s != "/Foo/" {
+		t.Error(s)
+	}
+}
+
+func TestHitPaths(t *testing.T) {
+	users, _ := NewPathRule("/users/:id", "")
+	c := New(Dir(t.TempDir()), Paths(users), TrimTrailingSlash(), KeepQuery("q"))
+	for target, uri := range map[string]string{
+		"/null.gif?u=" + url.QueryEscape("http://example.com/users/42/?q=1&fbclid=x"): "/users/:id?q=1",
+		"/null.gif?u=" + url.QueryEscape("http://example.com/search/?q=a,b"):          "/search?q=a%2Cb",
+		"/null.gif?h=1&u=" + url.QueryEscape("http://example.com/app/#/users/42"):     "/app#/users/42",
+	} {
+		if hit := c.hit(browserRequest("GET", target), true); hit.URI != uri {
+			t.Error(target, hit.URI, uri)
+		}
+	}
+	if hit := c.hit(browserRequest("GET", "/users/7/?q=x"), false); hit.URI != "/users/:id?q=x" {
+		t.Error(hit.URI)
+	}
+}
@@ -0,0 +1,165 @@
+package nullitics
+
+import (
+	"bufio"
+	_ "embed" // embed package must be imported for embedded files to work
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Traffic channels, referrers are grouped into.
+const (
+	// ChannelDirect is used when there is no referrer
+	ChannelDirect = "direct"
+	// ChannelSearch is used for search engines
+	ChannelSearch = "search"
+	// ChannelSocial is used for social networks and messengers
+	ChannelSocial = "social"
+	// ChannelEmail is used for web mail and mail clients
+	ChannelEmail = "email"
+	// ChannelPaid is used for paid campaigns, detected by utm_medium
+	ChannelPaid = "paid"
+	// ChannelReferral is used for all other referring sites
+	ChannelReferral = "referral"
+)
+
+// PaidMediums is a list of utm_medium values, typical for paid campaigns.
+var PaidMediums = []string{"cpc", "ppc", "cpm", "cpv", "cpa", "paid", "paidsearch", "paid_search",
+	"paid-search", "paidsocial", "paid_social", "paid-social", "display", "banner", "retargeting"}
+
+// channel returns the traffic channel for the referrer channel and the
+// utm_medium campaign parameter, which takes precedence if it's known.
+func channel(refChannel, medium string) string {
+	medium = strings.ToLower(medium)
+	for _, paid := range PaidMediums {
+		if medium == paid {
+			return ChannelPaid
+		}
+	}
+	switch medium {
+	case "email", "e-mail", "newsletter":
+		return ChannelEmail
+	case "social", "social-network", "social_network":
+		return ChannelSocial
+	case "organic":
+		return ChannelSearch
+	}
+	return refChannel
+}
+
+// RefRule maps matching referrer host names to a canonical referrer name and
+// a traffic channel. Only one of Exact, Prefix, Suffix or Regexp should be set.
+type RefRule struct {
+	Exact   string
+	Prefix  string
+	Suffix  string
+	Regexp  *regexp.Regexp
+	Name    string // Canonical referrer name, empty to keep the host name
+	Channel string
+}
+
+// Match returns true if the host name matches the rule.
+func (rule *RefRule) Match(host string) bool {
+	switch {
+	case rule.Exact != "":
+		return host == rule.Exact
+	case rule.Prefix != "":
+		return strings.HasPrefix(host, rule.Prefix)
+	case rule.Suffix != "":
+		return strings.HasSuffix(host, rule.Suffix)
+	case rule.Regexp != nil:
+		return rule.Regexp.MatchString(host)
+	}
+	return false
+}
+
+// RefRules is an ordered list of referrer rules, the first matching rule wins.
+type RefRules []RefRule
+
+//go:embed referrers.txt
+var referrersTxt string
+
+// DefaultRefRules is the built-in set of referrer rules for the most common
+// search engines, social networks and mail clients, loaded from the embedded
+// referrers.txt file.
+var DefaultRefRules = mustParseRefRules(referrersTxt)
+
+func mustParseRefRules(s string) RefRules {
+	rules, err := ParseRefRules(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// ParseRefRules reads referrer rules, one per line, in the format of
+// "<match> <pattern> <name> <channel>", where match is one of "exact",
+// "suffix", "prefix" or "regex", and name "-" keeps the host name as is.
+// Empty lines and lines starting with "#" are ignored.
+func ParseRefRules(r io.Reader) (RefRules, error) {
+	rules := RefRules{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, errors.New("expected four fields per rule: " + line)
+		}
+		rule := RefRule{Name: fields[2], Channel: fields[3]}
+		if rule.Name == "-" {
+			rule.Name = ""
+		}
+		switch pattern := fields[1]; fields[0] {
+		case "exact":
+			rule.Exact = pattern
+		case "prefix":
+			rule.Prefix = pattern
+		case "suffix":
+			rule.Suffix = pattern
+		case "regex":
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, err
+			}
+			rule.Regexp = re
+		default:
+			return nil, errors.New("unknown match type: " + fields[0])
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// LoadRefRules reads referrer rules from the given file, see ParseRefRules for
+// the file format.
+func LoadRefRules(filename string) (RefRules, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRefRules(f)
+}
+
+// Find returns the canonical name and the channel for the given referrer host.
+// Hosts without matching rules are considered to be regular referrals.
+func (rules RefRules) Find(host string) (name, channel string) {
+	if host == "" {
+		return "", ChannelDirect
+	}
+	for i := range rules {
+		if rule := &rules[i]; rule.Match(host) {
+			if rule.Name != "" {
+				host = rule.Name
+			}
+			return host, rule.Channel
+		}
+	}
+	return host, ChannelReferral
+}
@@ -0,0 +1,144 @@
+package nullitics
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidateRef(t *testing.T) {
+	for _, test := range []struct {
+		ref     string
+		name    string
+		channel string
+	}{
+		{"", "", ChannelDirect},
+		{"https://www.google.com/", "google.com", ChannelSearch},
+		{"https://google.de/search?q=foo", "google.com", ChannelSearch},
+		{"https://www.google.co.uk/", "google.com", ChannelSearch},
+		{"https://news.google.com/", "google.com", ChannelSearch},
+		{"android-app://com.google.android.googlequicksearchbox/", "google.com", ChannelSearch},
+		{"https://mail.google.com/mail/u/0/", "mail.google.com", ChannelEmail},
+		{"android-app://com.google.android.gm/", "mail.google.com", ChannelEmail},
+		{"https://webmail.example.com/", "webmail.example.com", ChannelEmail},
+		{"https://cc.bingj.com/", "cc.bingj.com", ChannelReferral},
+		{"https://www.bing.com/", "bing.com", ChannelSearch},
+		{"https://l.facebook.com/l.php?u=foo", "facebook.com", ChannelSocial},
+		{"https://lm.facebook.com/", "facebook.com", ChannelSocial},
+		{"https://t.co/abc", "twitter.com", ChannelSocial},
+		{"android-app://com.reddit.frontpage", "reddit.com", ChannelSocial},
+		{"https://old.reddit.com/r/golang", "reddit.com", ChannelSocial},
+		{"https://www.pinterest.co.uk/", "pinterest.com", ChannelSocial},
+		{"https://news.ycombinator.com/item?id=1", "news.ycombinator.com", ChannelSocial},
+		{"https://en.wikipedia.org/wiki/Go", "wikipedia.org", ChannelReferral},
+		{"https://www.example.com/blog", "example.com", ChannelReferral},
+	} {
+		name, channel := validateRef(test.ref, DefaultRefRules)
+		if name != test.name || channel != test.channel {
+			t.Error(test, name, channel)
+		}
+	}
+}
+
+func TestCustomRefRules(t *testing.T) {
+	c := New(Referrers(
+		RefRule{Suffix: ".example.com", Name: "example.com", Channel: ChannelSocial},
+		RefRule{Regexp: regexp.MustCompile(`^google\.`), Name: "google", Channel: ChannelSearch},
+	))
+	for ref, name := range map[string]string{
+		"https://foo.example.com/": "example.com",
+		"https://google.de/":       "google",
+		"https://duckduckgo.com/":  "duckduckgo.com",
+	} {
+		if n, _ := validateRef(ref, c.refs); n != name {
+			t.Error(ref, n, name)
+		}
+	}
+}
+
+func TestParseRefRules(t *testing.T) {
+	rules, err := ParseRefRules(strings.NewReader(`
+# comment
+exact  foo.com  -        social
+prefix bar.     bar.com  email
+`))
+	if err != nil || len(rules) != 2 {
+		t.Fatal(rules, err)
+	}
+	if name, channel := rules.Find("foo.com"); name != "foo.com" || channel != ChannelSocial {
+		t.Error(name, channel)
+	}
+	if name, channel := rules.Find("bar.example.com"); name != "bar.com" || channel != ChannelEmail {
+		t.Error(name, channel)
+	}
+	for _, s := range []string{"exact foo.com", "glob foo.* foo.com social", "regex ( foo social"} {
+		if _, err := ParseRefRules(strings.NewReader(s)); err == nil {
+			t.Error(s)
+		}
+	}
+}
+
+func TestChannel(t *testing.T) {
+	for _, test := range []struct {
+		ref     string
+		medium  string
+		channel string
+	}{
+		{ChannelDirect, "", ChannelDirect},
+		{ChannelSearch, "", ChannelSearch},
+		{ChannelSearch, "CPC", ChannelPaid},
+		{ChannelSocial, "paid_social", ChannelPaid},
+		{ChannelDirect, "email", ChannelEmail},
+		{ChannelReferral, "social", ChannelSocial},
+		{ChannelReferral, "unknown", ChannelReferral},
+	} {
+		if ch := channel(test.ref, test.medium); ch != test.channel {
+			t.Error(test, ch)
+		}
+	}
+}
+
+func TestHitChannel(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	for target, ch := range map[string]string{
+		"/null.gif?u=http://example.com/":                                             ChannelDirect,
+		"/null.gif?u=http://example.com/&r=https://www.google.com/":                   ChannelSearch,
+		"/null.gif?u=http%3A%2F%2Fexample.com%2F%3Futm_medium%3Dcpc&r=https://t.co/x": ChannelPaid,
+	} {
+		if hit := c.hit(browserRequest("GET", target), true); hit.Channel != ch {
+			t.Error(target, hit)
+		}
+	}
+}
+
+func TestInternalRef(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	for target, ref := range map[string]string{
+		"/null.gif?u=https://example.com/b&r=https://example.com/a":     "",
+		"/null.gif?u=https://www.example.com/b&r=https://example.com/a": "",
+		"/null.gif?u=https://example.com/b&r=https://WWW.Example.com/a": "",
+		"/null.gif?u=https://example.com/b&r=https://example.org/a":     "example.org",
+	} {
+		if hit := c.hit(browserRequest("GET", target), true); hit.Ref != ref {
+			t.Error(target, hit)
+		} else if ref == "" && hit.Channel != ChannelDirect {
+			t.Error(target, hit)
+		}
+	}
+	// Middleware uses request host
+	r := browserRequest("GET", "http://example.com:8080/?utm_source=http://example.com")
+	if hit := c.hit(r, false); hit.Ref != "" {
+		t.Error(hit)
+	}
+	// Explicitly configured hosts
+	c = New(Dir(t.TempDir()), Hosts("example.com", "example.org"))
+	for target, ref := range map[string]string{
+		"/null.gif?u=https://example.com/b&r=https://example.org/a":    "",
+		"/null.gif?u=https://example.net/b&r=https://example.net/a":    "example.net",
+		"/null.gif?u=https://example.com/b&r=https://blog.example.org": "blog.example.org",
+	} {
+		if hit := c.hit(browserRequest("GET", target), true); hit.Ref != ref {
+			t.Error(target, hit)
+		}
+	}
+}
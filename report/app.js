@@ -1,5 +1,7 @@
 const HOUR = 60 * 60 * 1000;
 const DAY = 24 * HOUR;
+// Traffic channels in the order of the stacked channels graph
+const CHANNELS = ['direct', 'search', 'social', 'email', 'paid', 'referral'];
 
 // Zeros returns an array of N elements filled with zeros.
 const zeros = n => Array(n).fill(0);
@@ -7,37 +9,59 @@ const zeros = n => Array(n).fill(0);
 const sum = a => a.reduce((acc, i) => (acc + i) | 0, 0);
 // Total returns the sum of all elements in a matrix M (array of arrays).
 const total = m => sum(m.map(a => sum(a)));
-const extend = (a, from, n) => zeros(n).map((_, i) => a[from + i] || 0);
-const framify = ({Rows}, from, n) =>
-  Rows.map(({Name, Values}) => [Name, ...extend(Values, from, n)]);
+const framify = ({Rows}, columns) =>
+  Rows.map(({Name, Values}) => [Name, ...columns.map(i => Values[i] || 0)]);
 
-const slice = (start, end, key) => {
+// Columns returns the history column indexes and labels within the date
+// range. Downsampled weekly or monthly periods come before the daily columns
+// and are included if they overlap with the range.
+const columns = (data, start, end) => {
+  const dayFmt = new Intl.DateTimeFormat([], {day: '2-digit', month: 'short'});
+  const monthFmt = new Intl.DateTimeFormat([], {month: 'short', year: 'numeric'});
+  const oldest = day(new Date(data.Start));
+  const periods = (data.Periods || []).map(p => new Date(p));
+  const first = periods.length > 0 ? periods[0] : oldest;
+  const result = [];
+  // Days before the oldest data have no columns and are shown as zeros
+  for (let d = new Date(start); d < end && d < first; d.setDate(d.getDate() + 1)) {
+    result.push([-1, dayFmt.format(d)]);
+  }
+  periods.forEach((p, i) => {
+    const next = i + 1 < periods.length ? periods[i + 1] : oldest;
+    if (p < end && next > start) {
+      result.push([i, next - p > 7 * DAY ? monthFmt.format(p) : `wk ${dayFmt.format(p)}`]);
+    }
+  });
+  for (let d = new Date(Math.max(start, oldest)); d < end; d.setDate(d.getDate() + 1)) {
+    result.push([periods.length + Math.round((d - oldest) / DAY), dayFmt.format(d)]);
+  }
+  return result;
+};
+
+// Slice returns the rows of the frame and the column labels within the date
+// range. Today's range is sliced from the hourly daily data, unless the
+// history data to slice is given explicitly.
+const slice = (start, end, key, data) => {
   start.setHours(0, 0, 0, 0);
   end.setHours(0, 0, 0, 0);
-  let buckets = Math.max(Math.ceil((end - start) / DAY), 0);
-  let from = 0;
-  let source = fullData;
-  let fmt = new Intl.DateTimeFormat([], {day: '2-digit', month: 'short'});
-  let increment = DAY;
-  if (buckets <= 1 && end.getTime() === today.getTime()) {
-    buckets = 24;
+  let source = data || fullData;
+  let cols = [];
+  if (!data && Math.ceil((end - start) / DAY) <= 1 && end.getTime() === today.getTime()) {
     source = dailyData;
-    fmt = new Intl.DateTimeFormat([], {
+    const fmt = new Intl.DateTimeFormat([], {
       hour: '2-digit',
       hourCycle: 'h23',
       minute: '2-digit',
     });
-    increment = HOUR;
+    cols = zeros(24).map((_, i) => [i, fmt.format(new Date(start.getTime() + HOUR * i))]);
   } else {
-    from = Math.ceil((start - oldest) / DAY);
+    cols = columns(source, start, end);
   }
-  const labels = zeros(buckets).map((_, i) =>
-    fmt.format(new Date(start.getTime() + increment * i)),
-  );
+  const labels = cols.map(([_, label]) => label);
   if (!source[key] || !source[key].Rows) {
     return [[], labels];
   }
-  const frame = framify(source[key], from, buckets).filter(row => row.slice(1).some(x => x != 0));
+  const frame = framify(source[key], cols.map(([i]) => i)).filter(row => row.slice(1).some(x => x != 0));
   return [frame, labels];
 };
 
@@ -50,35 +74,223 @@ const rank = key => {
   return [reduced, total];
 };
 let today = new Date();
-let oldest = new Date(fullData.Start);
 today.setHours(0, 0, 0, 0);
-oldest.setHours(0, 0, 0, 0);
 
-const sliceMap = (start, end, key) => {
-  const [items] = slice(start, end, key);
+const sliceMap = (start, end, key, data) => {
+  const [items] = slice(start, end, key, data);
   return items.reduce((m, [key, ...value]) => ({...m, [key]: value.reduce((a, n) => a+n, 0)}), {});
 }
 
-const render = () => {
-  const {from, to} = document.querySelector('nu-date-range');
+// Goal breakdown frames and the frames with the corresponding session counts
+const GOAL_DIMENSIONS = [
+  ['GoalRefs', 'Refs', 'Referrers'],
+  ['GoalCountries', 'Countries', 'Countries'],
+  ['GoalDevices', 'Devices', 'Devices'],
+];
+
+// Split goal breakdown items named "goal|value" into per-goal items.
+const splitGoals = items =>
+  Object.keys(items).reduce((m, key) => {
+    const i = key.lastIndexOf('|');
+    const [goal, value] = [key.slice(0, i), key.slice(i + 1)];
+    m[goal] = {...m[goal], [value]: items[key]};
+    return m;
+  }, {});
+
+const renderGoals = (from, to, visitors) => {
+  const goals = sliceMap(from, to, 'Goals');
+  const list = document.querySelector('.goals .goal-list');
+  list.total = visitors;
+  list.items = goals;
+  const breakdown = document.querySelector('.goals .goal-breakdown');
+  breakdown.innerHTML = '';
+  const dimensions = GOAL_DIMENSIONS.map(([key, base, title]) =>
+    [splitGoals(sliceMap(from, to, key)), sliceMap(from, to, base), title]);
+  Object.keys(goals).forEach(goal => {
+    const h3 = document.createElement('h3');
+    h3.textContent = goal;
+    breakdown.appendChild(h3);
+    dimensions.forEach(([conversions, sessions, title]) => {
+      const h4 = document.createElement('h4');
+      h4.textContent = title;
+      const table = document.createElement('nu-table');
+      table.limit = 10;
+      table.totals = sessions;
+      table.items = conversions[goal] || {};
+      breakdown.append(h4, table);
+    });
+  });
+};
+
+// Group funnel step items named "funnel|n|step" into ordered [step, count]
+// pairs for each funnel.
+const splitFunnels = items =>
+  Object.keys(items).reduce((m, key) => {
+    const [funnel, n, ...step] = key.split('|');
+    m[funnel] = m[funnel] || [];
+    m[funnel][n - 1] = [step.join('|'), items[key]];
+    return m;
+  }, {});
+
+const renderFunnels = (from, to) => {
+  // Steps nobody reached within the range are still shown with zero counts
+  const names = [fullData, dailyData].flatMap(({Funnels}) => (Funnels && Funnels.Rows) || []);
+  const steps = names.reduce((m, {Name}) => ({...m, [Name]: 0}), {});
+  const funnels = splitFunnels({...steps, ...sliceMap(from, to, 'Funnels')});
+  const list = document.querySelector('.funnels .funnel-list');
+  list.innerHTML = '';
+  if (Object.keys(funnels).length === 0) {
+    list.innerHTML = '<p class="no-data">No data</p>';
+    return;
+  }
+  Object.keys(funnels).forEach(name => {
+    const h3 = document.createElement('h3');
+    h3.textContent = name;
+    const funnel = document.createElement('nu-funnel');
+    funnel.steps = funnels[name];
+    list.append(h3, funnel);
+  });
+};
+
+// History of the previous period, used for comparison
+let previousData = null;
+let previousRange = '';
+
+// Previous returns the period of the same length that precedes the date range.
+const previous = (from, to) => {
+  const n = Math.max(Math.round((to - from) / DAY), 1);
+  const start = new Date(from);
+  start.setDate(start.getDate() - n);
+  return [start, new Date(from)];
+};
+
+// Bucket returns the column size for the date range, long ranges are shown by
+// weeks or months.
+const bucket = (from, to) => {
+  const days = from ? (to - from) / DAY : Infinity;
+  return days > 186 ? 'month' : days > 62 ? 'week' : '';
+};
+
+// First returns the date of the first history column.
+const first = data => day(new Date((data.Periods || [])[0] || data.Start));
+
+// Fetch requests the history for the date range from the server. Empty start
+// date means all time.
+const fetchRange = async (from, to) => {
+  const b = bucket(from, to);
+  const res = await fetch(`${location.pathname}?from=${from ? formatDate(from) : ''}&to=${formatDate(to)}${b ? `&bucket=${b}` : ''}`);
+  if (!res.ok) {
+    throw new Error(`failed to load stats: ${res.status}`);
+  }
+  return res.json();
+};
+
+// Load fetches the history for the date range and the previous period from
+// the server, unless they are already loaded.
+const load = async (from, to) => {
+  const range = `${from ? formatDate(from) : ''}/${formatDate(to)}`;
+  // All time range has no previous period
+  const [prevFrom, prevTo] = from ? previous(from, to) : [];
+  const prevRange = from ? `${formatDate(prevFrom)}/${formatDate(prevTo)}` : '';
+  // Today's stats come from the daily data
+  const hourly = from && to - from <= DAY && to.getTime() === today.getTime();
+  const [current, prev] = await Promise.all([
+    range === fullRange || hourly ? fullData : fetchRange(from, to),
+    !from ? null : prevRange === previousRange ? previousData : fetchRange(prevFrom, prevTo),
+  ]);
+  if (!hourly) {
+    [fullData, fullRange] = [current, range];
+  }
+  [previousData, previousRange] = [prev, prevRange];
+};
+
+// Compare returns true if the comparison series should be shown in graphs.
+const compare = () => window.localStorage['nu-compare'] === 'true';
+
+const toggleCompare = on => {
+  window.localStorage['nu-compare'] = on;
+  render();
+};
+
+const render = async () => {
+  const range = document.querySelector('nu-date-range');
+  const to = day(range.to);
+  await load(range.from && day(range.from), to);
+  const from = range.from ? day(range.from) : first(fullData);
+  // Previous period is missing for all time range
+  const [prevFrom, prevTo] = previous(from, to);
   document.querySelectorAll('[data-filter]').forEach(el => {
-      const x = sliceMap(from, to, el.dataset.filter);
+      el.previous = previousData ? sliceMap(prevFrom, prevTo, el.dataset.filter, previousData) : undefined;
       el.items = sliceMap(from, to, el.dataset.filter);
   });
   const sum = v => v.reduce((a, i) => a + i, 0);
   const [paths, labels] = slice(from, to, 'URIs');
   const [[sessions = zeros(labels.length+1)]] = slice(from, to, 'Sessions');
+  const [[bots = zeros(labels.length+1)]] = slice(from, to, 'Bots');
   const views = labels.map((_, i) => paths.reduce((a, p) => a + p[i+1], 0));
   const totalSessions = sum(sessions.slice(1));
   const totalViews = sum(views);
 
-  document.querySelector('nu-summary').visitors = totalSessions;
-  document.querySelector('nu-summary').views = totalViews;
-  document.querySelector('nu-graph').labels = labels;
-  document.querySelector('nu-graph').points = [views, sessions.slice(1)];
+  const summary = document.querySelector('nu-summary');
+  let comparison = null;
+  summary.previous = undefined;
+  if (previousData) {
+    const [prevPaths, prevLabels] = slice(prevFrom, prevTo, 'URIs', previousData);
+    const [[prevSessions = zeros(prevLabels.length+1)]] = slice(prevFrom, prevTo, 'Sessions', previousData);
+    summary.previous = {
+      visitors: sum(prevSessions.slice(1)),
+      views: sum(prevPaths.map(p => sum(p.slice(1)))),
+      bots: sum(Object.values(sliceMap(prevFrom, prevTo, 'Bots', previousData))),
+    };
+    // Previous visitors are only comparable if the columns match
+    if (compare() && prevLabels.length === labels.length) {
+      comparison = prevSessions.slice(1);
+    }
+  }
+  summary.visitors = totalSessions;
+  summary.views = totalViews;
+  summary.bots = sum(bots.slice(1));
+  renderGoals(from, to, totalSessions);
+  renderFunnels(from, to);
+  const graph = document.querySelector('.sessions nu-graph');
+  graph.comparison = comparison;
+  graph.labels = labels;
+  graph.points = [views, sessions.slice(1)];
+
+  const [channels] = slice(from, to, 'Channels');
+  document.querySelector('.channels nu-graph').labels = labels;
+  document.querySelector('.channels nu-graph').points = CHANNELS.map(ch =>
+    (channels.find(([name]) => name === ch) || [ch, ...zeros(labels.length)]).slice(1));
+};
+
+// Download exports the frame (or all frames) for the selected date range.
+const download = (frame, format) => {
+  const range = document.querySelector('nu-date-range');
+  const from = range.from ? formatDate(day(range.from)) : '';
+  window.location = `${location.pathname}?export=${frame}&format=${format}&from=${from}&to=${formatDate(day(range.to))}`;
+};
+document.addEventListener('export', e => download(e.detail.frame, e.detail.format));
+
+// Live subscribes to the current visitors stream and shows them in the header.
+const live = () => {
+  if (!window.EventSource) {
+    return;
+  }
+  const badge = document.querySelector('.live');
+  const source = new EventSource(`${location.pathname}?live`);
+  source.onmessage = e => {
+    const {Visitors, Pages} = JSON.parse(e.data);
+    badge.classList.remove('hidden');
+    badge.querySelector('.live-count').textContent = Visitors;
+    document.querySelector('.live-pages').items =
+      Pages.reduce((m, {URI, Visitors}) => ({...m, [URI]: Visitors}), {});
+  };
+  source.onerror = () => badge.classList.add('hidden');
 };
 
 window.onload = () => {
+  document.querySelector('.compare-toggle input').checked = compare();
   render();
+  live();
   window.cloak.classList.remove('hidden');
 };
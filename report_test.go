@@ -0,0 +1,59 @@
+package nullitics
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportRange(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	for i := 0; i < 40; i++ {
+		if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(24 * time.Hour)
+	}
+	for target, n := range map[string]int{
+		"/?from=2021-01-05&to=2021-01-12": 7,
+		"/?from=2021-02-05&to=2021-02-15": 5,
+		"/?from=2020-12-30&to=2021-01-02": 1,
+		"/?to=2021-01-03":                 2,
+		"/?to=2021-03-01&bucket=month":    40,
+	} {
+		w := httptest.NewRecorder()
+		c.Report(nil).ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		stats := &Stats{}
+		if err := json.NewDecoder(w.Body).Decode(stats); err != nil {
+			t.Fatal(target, err)
+		}
+		if w.Header().Get("Content-Type") != "application/json" || stats.Sessions.Row("sessions").Last(100) != n {
+			t.Error(target, stats.Sessions.Rows)
+		}
+	}
+	for _, target := range []string{"/?from=2021-01-05", "/?from=yesterday&to=2021-01-02", "/?to=2021-01-02&bucket=year"} {
+		w := httptest.NewRecorder()
+		c.Report(nil).ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != 400 {
+			t.Error(target, w.Code)
+		}
+	}
+	// Far future or past ranges are limited to the stored history
+	for _, target := range []string{"/?to=9999-12-31", "/?from=0001-01-01&to=9999-12-31"} {
+		w := httptest.NewRecorder()
+		c.Report(nil).ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != 200 || w.Body.Len() > 4096 {
+			t.Error(target, w.Code, w.Body.Len())
+		}
+	}
+	// The dashboard embeds only the last 30 days
+	w := httptest.NewRecorder()
+	c.Report(nil).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); !strings.Contains(body, `let fullRange = "2021-01-11/2021-02-10"`) {
+		t.Error("range is not embedded")
+	}
+}
@@ -0,0 +1,164 @@
+package nullitics
+
+import "time"
+
+// Downsampling periods for the historical stats.
+const (
+	Weekly  = "week"
+	Monthly = "month"
+)
+
+// Retention is the data retention policy for the historical stats.
+type Retention struct {
+	// Days is the number of days to keep with daily resolution. Older data is
+	// rolled into the coarser columns of the given Period. Zero keeps daily
+	// resolution forever.
+	Days   int
+	Period string
+	// MaxAge is the number of days after which the data is deleted. Zero keeps
+	// the data forever.
+	MaxAge int
+}
+
+// apply downsamples and expires the stats as of the given day.
+func (r Retention) apply(stats *Stats, today time.Time) {
+	if r.MaxAge > 0 {
+		stats.Expire(today.AddDate(0, 0, -r.MaxAge))
+	}
+	if r.Days > 0 && (r.Period == Weekly || r.Period == Monthly) {
+		stats.Downsample(today.AddDate(0, 0, -r.Days), r.Period)
+	}
+}
+
+// nextPeriod returns the start of the week or month that follows t.
+func nextPeriod(t time.Time, period string) time.Time {
+	if period == Monthly {
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return date(t).AddDate(0, 0, 7-(int(t.Weekday())+6)%7)
+}
+
+// days returns the number of calendar days between the two dates.
+func days(from, to time.Time) int {
+	return int((to.Sub(from) + 12*time.Hour) / (24 * time.Hour))
+}
+
+// width returns the number of columns in the stats.
+func (stats *Stats) width() int {
+	n := 0
+	for _, frame := range stats.frames() {
+		if l := frame.Len(); l > n {
+			n = l
+		}
+	}
+	return n
+}
+
+// Downsample rolls the daily columns into weekly or monthly ones, as long as
+// the whole period ends before the given time. Periods are aligned to Mondays
+// or to the first days of the months.
+func (stats *Stats) Downsample(before time.Time, period string) {
+	for !stats.Start.IsZero() {
+		end := nextPeriod(stats.Start, period)
+		n := stats.width() - len(stats.Periods)
+		if n <= 0 || end.After(before) {
+			return
+		}
+		k := days(stats.Start, end)
+		if k > n {
+			k = n
+		}
+		p := len(stats.Periods)
+		for _, frame := range stats.frames() {
+			frame.squash(p, p+k, false)
+		}
+		stats.Periods = append(stats.Periods, stats.Start)
+		stats.Start = end
+	}
+}
+
+// Bucket returns a copy of the stats with all the daily columns rolled into
+// weekly or monthly periods, including the last incomplete one. It is used to
+// display long time ranges.
+func (stats *Stats) Bucket(period string) *Stats {
+	n := stats.width() - len(stats.Periods)
+	s := stats.Slice(time.Time{}, stats.Start.AddDate(0, 0, n))
+	s.Downsample(nextPeriod(s.Start.AddDate(0, 0, n), period), period)
+	return s
+}
+
+// First returns the start time of the first column.
+func (stats *Stats) First() time.Time {
+	if len(stats.Periods) > 0 {
+		return stats.Periods[0]
+	}
+	return stats.Start
+}
+
+// Expire deletes the columns that end before the given time, and the rows
+// that become empty.
+func (stats *Stats) Expire(before time.Time) {
+	for len(stats.Periods) > 0 {
+		end := stats.Start
+		if len(stats.Periods) > 1 {
+			end = stats.Periods[1]
+		}
+		if end.After(before) {
+			break
+		}
+		for _, frame := range stats.frames() {
+			frame.squash(0, 1, true)
+		}
+		stats.Periods = stats.Periods[1:]
+	}
+	if len(stats.Periods) == 0 && !stats.Start.IsZero() && stats.Start.Before(before) {
+		k := days(stats.Start, before)
+		for _, frame := range stats.frames() {
+			frame.squash(0, k, true)
+		}
+		stats.Start = stats.Start.AddDate(0, 0, k)
+	}
+	for _, frame := range stats.frames() {
+		frame.compact()
+	}
+}
+
+// squash replaces the columns in the given range with their sum, or deletes
+// them if drop is true.
+func (f *Frame) squash(from, to int, drop bool) {
+	n := f.Len()
+	if to > n {
+		to = n
+	}
+	if from >= to {
+		return
+	}
+	for i := range f.Rows {
+		row := &f.Rows[i]
+		values := append([]int{}, row.Values[:from]...)
+		if !drop {
+			sum := 0
+			for _, v := range row.Values[from:to] {
+				sum += v
+			}
+			values = append(values, sum)
+		}
+		row.Values = append(values, row.Values[to:]...)
+	}
+	if drop {
+		f.len = n - (to - from)
+	} else {
+		f.len = n - (to - from) + 1
+	}
+}
+
+// compact deletes the rows with all zero values.
+func (f *Frame) compact() {
+	rows := f.Rows[:0]
+	for _, row := range f.Rows {
+		if row.Last(len(row.Values)) != 0 {
+			rows = append(rows, row)
+		}
+	}
+	f.Rows = rows
+}
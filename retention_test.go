@@ -0,0 +1,134 @@
+package nullitics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+func TestNextPeriod(t *testing.T) {
+	for _, test := range []struct {
+		t      time.Time
+		period string
+		next   time.Time
+	}{
+		{day(2021, 1, 6), Weekly, day(2021, 1, 11)},
+		{day(2021, 1, 10), Weekly, day(2021, 1, 11)},
+		{day(2021, 1, 11), Weekly, day(2021, 1, 18)},
+		{day(2021, 1, 1), Monthly, day(2021, 2, 1)},
+		{day(2021, 12, 31), Monthly, day(2022, 1, 1)},
+	} {
+		if next := nextPeriod(test.t, test.period); !next.Equal(test.next) {
+			t.Error(test.t, test.period, next)
+		}
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	stats := &Stats{Start: day(2021, 1, 6), Interval: 24 * time.Hour}
+	stats.URIs.Grow(20)
+	for i := range stats.URIs.Row("/a").Values {
+		stats.URIs.Row("/a").Values[i] = i + 1
+	}
+	stats.URIs.Row("/b").Values[0] = 1
+	stats.Downsample(day(2021, 1, 25), Weekly)
+	if !reflect.DeepEqual(stats.Periods, []time.Time{day(2021, 1, 6), day(2021, 1, 11), day(2021, 1, 18)}) {
+		t.Error(stats.Periods)
+	}
+	if !stats.Start.Equal(day(2021, 1, 25)) || !reflect.DeepEqual(stats.URIs.Row("/a").Values, []int{15, 63, 112, 20}) {
+		t.Error(stats.Start, stats.URIs.Rows)
+	}
+	// Periods survive CSV round trip
+	parsed, err := ParseStatsCSV(stats.CSV())
+	if err != nil || !reflect.DeepEqual(parsed.Periods, stats.Periods) || !parsed.Start.Equal(stats.Start) {
+		t.Error(parsed, err)
+	}
+	stats.Expire(day(2021, 1, 18))
+	if !reflect.DeepEqual(stats.Periods, []time.Time{day(2021, 1, 18)}) || !reflect.DeepEqual(stats.URIs.Row("/a").Values, []int{112, 20}) {
+		t.Error(stats.Periods, stats.URIs.Rows)
+	}
+	if _, found := stats.URIs.find("/b"); found {
+		t.Error("empty row is not deleted")
+	}
+	stats.Expire(day(2021, 1, 26))
+	if len(stats.Periods) != 0 || !stats.Start.Equal(day(2021, 1, 26)) || len(stats.URIs.Rows) != 0 {
+		t.Error(stats.Periods, stats.Start, stats.URIs.Rows)
+	}
+}
+
+func TestDownsampleMonthly(t *testing.T) {
+	stats := &Stats{Start: day(2021, 1, 30), Interval: 24 * time.Hour}
+	stats.Sessions.Grow(5)
+	copy(stats.Sessions.Row("sessions").Values, []int{1, 2, 3, 4, 5})
+	stats.Downsample(day(2021, 3, 1), Monthly)
+	if !reflect.DeepEqual(stats.Periods, []time.Time{day(2021, 1, 30), day(2021, 2, 1)}) || !stats.Start.Equal(day(2021, 3, 1)) {
+		t.Error(stats.Periods, stats.Start)
+	}
+	if v := stats.Sessions.Row("sessions").Values; !reflect.DeepEqual(v, []int{3, 12}) {
+		t.Error(v)
+	}
+}
+
+func TestCollectorRetention(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(dir), Location(time.UTC), Clock(clock.Now), Retain(Retention{Days: 14, Period: Weekly, MaxAge: 60}))
+	for i := 0; i < 100; i++ {
+		if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(24 * time.Hour)
+	}
+	c.Close()
+	c = New(Dir(dir), Location(time.UTC), Clock(clock.Now))
+	defer c.Close()
+	_, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Today (Apr 10) is still in the daily log, daily columns start from the last
+	// Monday before the 14 days cutoff, data older than 60 days is expired
+	if len(history.Periods) == 0 || history.Periods[0].Before(day(2021, 2, 8)) {
+		t.Error(history.Periods)
+	}
+	if !history.Start.Equal(day(2021, 3, 22)) {
+		t.Error(history.Start)
+	}
+	row := history.Sessions.Row("sessions")
+	if len(row.Values) != len(history.Periods)+days(history.Start, day(2021, 4, 10))+1 || row.Values[len(row.Values)-1] != 1 {
+		t.Error(history.Periods, history.Start, row.Values)
+	}
+	for _, v := range row.Values[:len(history.Periods)-1] {
+		if v != 7 {
+			t.Error(row.Values)
+		}
+	}
+}
+
+func TestBucket(t *testing.T) {
+	stats := &Stats{Start: day(2021, 1, 6), Interval: 24 * time.Hour}
+	stats.URIs.Grow(20)
+	for i := range stats.URIs.Row("/a").Values {
+		stats.URIs.Row("/a").Values[i] = i + 1
+	}
+	weekly := stats.Bucket(Weekly)
+	if !reflect.DeepEqual(weekly.URIs.Row("/a").Values, []int{15, 63, 112, 20}) || len(weekly.Periods) != 4 || !weekly.First().Equal(day(2021, 1, 6)) {
+		t.Error(weekly.Periods, weekly.URIs.Rows)
+	}
+	monthly := stats.Bucket(Monthly)
+	if !reflect.DeepEqual(monthly.URIs.Row("/a").Values, []int{210}) || !reflect.DeepEqual(monthly.Periods, []time.Time{day(2021, 1, 6)}) {
+		t.Error(monthly.Periods, monthly.URIs.Rows)
+	}
+	// Original stats are not modified
+	if len(stats.Periods) != 0 || len(stats.URIs.Row("/a").Values) != 20 {
+		t.Error(stats.URIs.Rows)
+	}
+	// Existing periods are kept as is
+	weekly.URIs.Grow(6)
+	copy(weekly.URIs.Row("/a").Values[4:], []int{1, 1})
+	if b := weekly.Bucket(Monthly); !reflect.DeepEqual(b.URIs.Row("/a").Values, []int{15, 63, 112, 20, 2}) {
+		t.Error(b.Periods, b.URIs.Rows)
+	}
+}
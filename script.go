@@ -0,0 +1,58 @@
+package nullitics
+
+import (
+	"crypto/sha256"
+	_ "embed" // embed package must be imported for embedded files to work
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ScriptVersion is the version of the embedded tracking script, shown in its
+// header comment.
+const ScriptVersion = "1.2.1"
+
+//go:embed script.js
+var scriptJS string
+
+var script = "/*! nullitics v" + ScriptVersion + " */\n" + compactJS(scriptJS)
+
+// scriptETag is the hash of the served script, so the cached copies are
+// refreshed whenever the script changes, even if the version is not bumped.
+var scriptETag = func() string {
+	sum := sha256.Sum256([]byte(script))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}()
+
+// compactJS removes indentation, empty lines and full-line comments, which is
+// safe for the hand-written tracking script. It is not a minifier, names and
+// line breaks are kept as is.
+func compactJS(s string) string {
+	b := &strings.Builder{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Script returns a handler that serves the tracking script. The script records
+// a page view on load and on every client-side route change, and sends the
+// hits to the collector API (see ServeHTTP).
+func (c *Collector) Script() http.Handler {
+	etag := scriptETag
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte(script))
+	})
+}
@@ -0,0 +1,110 @@
+// Nullitics tracking script. Add it to every page of the site:
+//
+//   <script async src="https://stats.example.com/null.js"></script>
+//
+// Hits are sent to the same URL with the ".gif" extension, which can be changed
+// with the data-api attribute. Add the data-hash attribute to track hash-based
+// routes as separate pages. Add the data-outbound attribute to track clicks on
+// external links, and the data-downloads attribute to track clicks on file
+// downloads (optionally with a comma-separated list of file extensions). Custom
+// events can be sent with nullitics.event('name').
+(function () {
+  'use strict';
+  var script = document.currentScript;
+  if (!script || window.nullitics) {
+    return;
+  }
+  var api = script.getAttribute('data-api') || script.src.replace(/\.js(\?.*)?$/, '.gif');
+  var hash = script.hasAttribute('data-hash');
+  var outbound = script.hasAttribute('data-outbound');
+  var downloads = script.getAttribute('data-downloads');
+  var extensions = (downloads || 'pdf,zip,gz,tgz,rar,7z,dmg,exe,msi,pkg,deb,rpm,apk,iso,doc,docx,xls,xlsx,ppt,pptx,csv,txt,epub,mp3,mp4,mov,avi')
+    .toLowerCase().split(',');
+  var ref = document.referrer;
+  var last = null;
+  var timer = null;
+  // Route changes within this many milliseconds are counted once
+  var delay = 300;
+
+  var encode = function (params) {
+    return Object.keys(params).map(function (k) {
+      return encodeURIComponent(k) + '=' + encodeURIComponent(params[k]);
+    }).join('&');
+  };
+
+  // Send uses the POST API via sendBeacon when possible, and falls back to the
+  // tracking pixel otherwise.
+  var send = function (params) {
+    var body = encode(params);
+    if (navigator.sendBeacon) {
+      var blob = new Blob([body], {type: 'application/x-www-form-urlencoded'});
+      if (navigator.sendBeacon(api, blob)) {
+        return;
+      }
+    }
+    new Image().src = api + '?' + body;
+  };
+
+  // Record sends a page view, unless the route has not changed since the last
+  // one, which happens on repeated history API calls.
+  var record = function () {
+    timer = null;
+    var route = location.pathname + (hash ? location.hash : '');
+    if (route === last) {
+      return;
+    }
+    last = route;
+    var params = {u: location.href, r: ref, d: screen.width};
+    if (hash) {
+      params.h = 1;
+    }
+    send(params);
+    ref = '';
+  };
+
+  // Track records a page view once the route settles, so that rapid repeats,
+  // like redirects or A-B-A navigations, are not counted.
+  var track = function () {
+    clearTimeout(timer);
+    timer = setTimeout(record, delay);
+  };
+
+  // Click records outbound link clicks and file downloads.
+  var click = function (e) {
+    if (e.type === 'auxclick' && e.button !== 1) {
+      return;
+    }
+    var a = e.target.closest ? e.target.closest('a[href]') : null;
+    if (!a || (a.protocol !== 'http:' && a.protocol !== 'https:')) {
+      return;
+    }
+    var file = a.pathname.split('/').pop();
+    var ext = file.indexOf('.') >= 0 ? file.split('.').pop().toLowerCase() : '';
+    if (downloads !== null && ext && extensions.indexOf(ext) >= 0) {
+      send({k: 'download', l: a.href, u: location.href});
+    } else if (outbound && a.host !== location.host) {
+      send({k: 'outbound', l: a.href, u: location.href});
+    }
+  };
+  if (outbound || downloads !== null) {
+    document.addEventListener('click', click, true);
+    document.addEventListener('auxclick', click, true);
+  }
+
+  var pushState = history.pushState;
+  if (pushState) {
+    history.pushState = function () {
+      pushState.apply(this, arguments);
+      track();
+    };
+    window.addEventListener('popstate', track);
+  }
+  if (hash) {
+    window.addEventListener('hashchange', track);
+  }
+  var event = function (name) {
+    send({k: 'event', e: name, u: location.href});
+  };
+  window.nullitics = {track: track, event: event};
+  record();
+})();
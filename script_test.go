@@ -0,0 +1,89 @@
+package nullitics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompactJS(t *testing.T) {
+	js := "// comment\n(function () {\n  var a = 'http://example.com';\n\n  // another\n  f(a);\n})();\n"
+	if s := compactJS(js); s != "(function () {\nvar a = 'http://example.com';\nf(a);\n})();\n" {
+		t.Error(s)
+	}
+}
+
+func TestScript(t *testing.T) {
+	h := New().Script()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/null.js", nil))
+	if w.Code != 200 || w.Header().Get("Content-Type") != "application/javascript" {
+		t.Error(w.Code, w.Header())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "v"+ScriptVersion) || !strings.Contains(body, "pushState") {
+		t.Error(body)
+	}
+	// ETag is derived from the served script
+	sum := sha256.Sum256(w.Body.Bytes())
+	if etag := w.Header().Get("ETag"); etag != `"`+hex.EncodeToString(sum[:8])+`"` {
+		t.Error(etag)
+	}
+	r := httptest.NewRequest("GET", "/null.js", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 304 || w.Body.Len() != 0 {
+		t.Error(w.Code, w.Body.String())
+	}
+}
+
+func TestPostAPI(t *testing.T) {
+	c := New(Dir(t.TempDir()))
+	defer c.Close()
+	for _, test := range []struct {
+		contentType string
+		body        string
+	}{
+		{"application/json", `{"u":"https://example.com/json","d":375}`},
+		{"application/json; charset=utf-8", `{"u":"https://example.com/charset"}`},
+		{"application/x-www-form-urlencoded", "u=https%3A%2F%2Fexample.com%2Fform&d=1280"},
+		{"application/x-www-form-urlencoded", "u=https%3A%2F%2Fexample.com%2Fapp%23%2Fusers&h=1"},
+	} {
+		r := browserRequest("POST", "/null.gif")
+		r.Body = ioutil.NopCloser(strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Code != 204 {
+			t.Error(test, w.Code)
+		}
+	}
+	// Malformed JSON is rejected and not recorded
+	r := browserRequest("POST", "/null.gif")
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"u":`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	if w.Code != 400 {
+		t.Error(w.Code)
+	}
+	daily, _, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uri := range []string{"/form", "/json", "/charset", "/app#/users"} {
+		if row := daily.URIs.Row(uri); row.Last(24) != 1 {
+			t.Error(uri, row)
+		}
+	}
+	if len(daily.URIs.Rows) != 4 {
+		t.Error(daily.URIs.Rows)
+	}
+	// Device is taken from the first hit of the session
+	if n := daily.Devices.Row(Mobile).Last(24); n != 1 {
+		t.Error(n)
+	}
+}
@@ -0,0 +1,155 @@
+package nullitics
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// saltDayFormat is the format of the days the salt keys are stored for.
+const saltDayFormat = "20060102"
+
+// sessionLength is the number of bytes of the keyed hash kept in session
+// identifiers.
+const sessionLength = 8
+
+// dailySalt is a random secret key that changes every day. It is kept on disk
+// so that sessions survive collector restarts, unless the filename is empty.
+// The key is destroyed, both in memory and on disk, as soon as its day ends,
+// so that the sessions of the past days can never be linked to the visitors
+// again.
+type dailySalt struct {
+	sync.Mutex
+	filename string
+	day      string
+	key      []byte
+	timer    *time.Timer
+	// lock, if set, guards the salt file shared by multiple processes
+	lock func() (func(), error)
+}
+
+// get returns the salt for the day of the given time, either the current one,
+// the one stored on disk, or a freshly generated one. It fails if the salt
+// file shared by multiple processes can not be locked, since the processes
+// would otherwise overwrite each other's salt, or if no random key can be
+// generated.
+func (ds *dailySalt) get(now time.Time) ([]byte, error) {
+	day := now.Format(saltDayFormat)
+	ds.Lock()
+	defer ds.Unlock()
+	if ds.day == day {
+		return ds.key, nil
+	}
+	if ds.lock != nil {
+		unlock, err := ds.lock()
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	// Destroy the key once the day ends, even if there are no more hits
+	if ds.timer != nil {
+		ds.timer.Stop()
+	}
+	next := date(now).AddDate(0, 0, 1)
+	ds.timer = time.AfterFunc(next.Sub(now), func() { ds.expire(next) })
+	if ds.filename == "" {
+		key, err := newSaltKey()
+		if err != nil {
+			return nil, err
+		}
+		ds.day, ds.key = day, key
+		return key, nil
+	}
+	if b, err := ioutil.ReadFile(ds.filename); err == nil {
+		parts := strings.Split(strings.TrimSpace(string(b)), ",")
+		if len(parts) == 2 && parts[0] == day {
+			if key, err := hex.DecodeString(parts[1]); err == nil && len(key) == sha256.Size {
+				ds.day, ds.key = day, key
+				return key, nil
+			}
+		}
+	}
+	key, err := newSaltKey()
+	if err != nil {
+		return nil, err
+	}
+	ds.day, ds.key = day, key
+	if err := ioutil.WriteFile(ds.filename, []byte(day+","+hex.EncodeToString(ds.key)+"\n"), 0600); err != nil {
+		log.Println("nullitics: sessions will not survive restarts:", err)
+	}
+	return ds.key, nil
+}
+
+// expire destroys the keys of the days before the given time.
+func (ds *dailySalt) expire(now time.Time) {
+	today := now.Format(saltDayFormat)
+	ds.Lock()
+	defer ds.Unlock()
+	if ds.day != "" && ds.day < today {
+		ds.day, ds.key = "", nil
+	}
+	if ds.filename == "" {
+		return
+	}
+	if ds.lock != nil {
+		unlock, err := ds.lock()
+		if err != nil {
+			log.Println("nullitics: failed to remove the expired salt:", err)
+			return
+		}
+		defer unlock()
+	}
+	b, err := ioutil.ReadFile(ds.filename)
+	if err != nil {
+		return
+	}
+	// The salt of today may have been written by another process already
+	if day := strings.SplitN(string(b), ",", 2)[0]; day < today {
+		if err := os.Remove(ds.filename); err != nil {
+			log.Println("nullitics: failed to remove the expired salt:", err)
+		}
+	}
+}
+
+// close stops the key expiration timer.
+func (ds *dailySalt) close() {
+	ds.Lock()
+	defer ds.Unlock()
+	if ds.timer != nil {
+		ds.timer.Stop()
+	}
+}
+
+// newSaltKey returns a random secret key. There is no fallback if the system
+// random source fails, since a predictable key would make the sessions
+// reversible.
+func newSaltKey() ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Session returns a keyed hash (HMAC-SHA256) of the user IP address, user
+// agent and a salt string, using the daily rotating key. It is unique enough
+// for most typical cases, and does not violate user's privacy since no
+// personal data is stored within a session and the key is destroyed after the
+// day ends.
+//
+// Older versions used 4-byte MD5 hashes. Such session identifiers in the
+// existing log files are still read as is, the only side effect of the upgrade
+// is that returning visitors may be counted twice on that day.
+func session(ip, ua, salt string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip + "\x00" + ua + "\x00" + salt))
+	return hex.EncodeToString(mac.Sum(nil)[:sessionLength])
+}
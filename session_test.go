@@ -0,0 +1,107 @@
+package nullitics
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	key := []byte("key")
+	s := session("1.2.3.4", "Mozilla", "salt", key)
+	if len(s) != 2*sessionLength {
+		t.Error(s)
+	}
+	if s != session("1.2.3.4", "Mozilla", "salt", key) {
+		t.Error("session must be stable")
+	}
+	for _, other := range []string{
+		session("1.2.3.5", "Mozilla", "salt", key),
+		session("1.2.3.4", "Chrome", "salt", key),
+		session("1.2.3.4", "Mozilla", "pepper", key),
+		session("1.2.3.4", "Mozilla", "salt", []byte("other key")),
+	} {
+		if s == other {
+			t.Error(other)
+		}
+	}
+}
+
+func TestDailySalt(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), saltFile)
+	day := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	ds := &dailySalt{filename: filename}
+	defer ds.close()
+	a, _ := ds.get(day)
+	// Salt is restored after restart
+	if b, _ := (&dailySalt{filename: filename}).get(day); string(a) != string(b) {
+		t.Error(a, b)
+	}
+	// Salt rotates and the old one is removed from disk
+	b, _ := ds.get(day.AddDate(0, 0, 1))
+	if string(a) == string(b) {
+		t.Error(a, b)
+	}
+	data, _ := ioutil.ReadFile(filename)
+	if !strings.HasPrefix(string(data), "20210102,") {
+		t.Error(string(data))
+	}
+	// Salt expires at the end of the day without any hits
+	ds.expire(day.AddDate(0, 0, 2))
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+	if ds.key != nil {
+		t.Error(ds.key)
+	}
+}
+
+func TestDailySaltExpireOnStart(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, saltFile)
+	if err := ioutil.WriteFile(filename, []byte("20210101,00\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFakeClock(time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC))
+	New(Dir(dir), Location(time.UTC), Clock(clock.Now)).Close()
+	if _, err := os.Stat(filename); err != nil {
+		t.Error(err)
+	}
+	clock.Add(time.Hour)
+	New(Dir(dir), Location(time.UTC), Clock(clock.Now)).Close()
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
+
+func TestDailySaltNoDir(t *testing.T) {
+	ds := &dailySalt{}
+	defer ds.close()
+	day := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	a, _ := ds.get(day)
+	if b, _ := ds.get(day); len(a) != sha256.Size || string(a) != string(b) {
+		t.Error(a, b)
+	}
+}
+
+func TestDailySaltLockError(t *testing.T) {
+	dir := t.TempDir()
+	c := New(Dir(dir), Shard("a"))
+	defer c.Close()
+	c.salts.lock = func() (func(), error) { return nil, errors.New("locks are not supported") }
+	hit := c.hit(browserRequest("GET", "/"), false)
+	if hit.Session != "" || hit.err == nil {
+		t.Error(hit)
+	}
+	if err := c.Hit(hit); err == nil {
+		t.Error("should fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, saltFile)); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,175 @@
+package nullitics
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sharedLock is the lock file that guards the daily rollover and the salt
+// rotation in the shared storage mode.
+var sharedLock = "stats.lock"
+
+// Shard enables the shared storage mode, in which multiple processes record
+// hits to the same Dir. Each process appends hits to its own log shard, named
+// "log.<name>.csv", the daily rollover is done under a file lock by the first
+// process that gets a hit for the next day, and the stats are read from all
+// the shards. Shard names must be unique file names, like host names. The
+// daily salt is shared by all the processes, so the Salt option, if given, must
+// be the same, too.
+func Shard(name string) Option { return func(c *Collector) { c.shard = name } }
+
+// logFile returns the log file name the collector appends hits to.
+func (c *Collector) logFile() string {
+	if c.shard == "" {
+		return dailyLog
+	}
+	return "log." + c.shard + ".csv"
+}
+
+// isLogFile returns true if the file name is a daily log or a log shard.
+func isLogFile(name string) bool {
+	return name == dailyLog || (strings.HasPrefix(name, "log.") && strings.HasSuffix(name, ".csv") &&
+		!strings.ContainsAny(name, `/\`))
+}
+
+// lockShared acquires the shared storage lock and returns a function that
+// releases it.
+func (c *Collector) lockShared() (func(), error) {
+	_ = os.MkdirAll(c.dir, 0777)
+	f, err := os.OpenFile(filepath.Join(c.dir, sharedLock), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// end returns the day after the last column of the stats, or zero time if
+// the stats are empty.
+func (stats *Stats) end() time.Time {
+	if stats.Start.IsZero() {
+		return time.Time{}
+	}
+	return stats.Start.AddDate(0, 0, stats.width()-len(stats.Periods))
+}
+
+// shardDay is a group of log shards with the hits of the same day.
+type shardDay struct {
+	day   time.Time
+	files []string
+}
+
+// unmergedShards returns the log shards that are not rolled into the history
+// yet, grouped by days in chronological order.
+func (c *Collector) unmergedShards() ([]shardDay, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "log.*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, filepath.Join(c.dir, dailyLog))
+	end := c.history.end()
+	byDay := map[time.Time][]string{}
+	for _, filename := range files {
+		f, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		start, err := readLogStart(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		} else if start.IsZero() {
+			continue
+		}
+		day := date(start.In(c.location))
+		if !end.IsZero() && day.Before(end) {
+			continue
+		}
+		byDay[day] = append(byDay[day], filename)
+	}
+	shards := []shardDay{}
+	for day, files := range byDay {
+		shards = append(shards, shardDay{day: day, files: files})
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].day.Before(shards[j].day) })
+	return shards, nil
+}
+
+// sharedStats re-reads the history written by any of the processes and
+// merges all the log shards that are not rolled into it yet. Daily stats are
+// the ones of the latest day.
+func (c *Collector) sharedStats() (*Stats, *Stats, error) {
+	c.history = nil
+	if err := c.checkHistoricalStats(); err != nil {
+		return nil, nil, err
+	}
+	shards, err := c.unmergedShards()
+	if err != nil {
+		return nil, nil, err
+	}
+	daily, err := c.readDailyStats(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, shard := range shards {
+		if daily, err = c.readDailyStats(shard.files); err != nil {
+			return nil, nil, err
+		}
+		c.mergeAppender(daily)
+	}
+	return daily, c.history, nil
+}
+
+// rolloverShards rolls all the log shards of the days before today into the
+// history under the shared lock, and truncates the own log shard. Shards of
+// other processes are truncated by them on their next rollover.
+func (c *Collector) rolloverShards(today time.Time) error {
+	unlock, err := c.lockShared()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := c.closeAppender(); err != nil {
+		return err
+	}
+	c.history = nil
+	if err := c.checkHistoricalStats(); err != nil {
+		return err
+	}
+	shards, err := c.unmergedShards()
+	if err != nil {
+		return err
+	}
+	merged := false
+	for _, shard := range shards {
+		if !shard.day.Before(today) {
+			continue
+		}
+		daily, err := c.readDailyStats(shard.files)
+		if err != nil {
+			return err
+		}
+		c.mergeAppender(daily)
+		merged = true
+	}
+	// Other process may have done the rollover already
+	if merged {
+		c.pruneHistoricalStats()
+		c.retention.apply(c.history, today)
+		if err := c.saveHistoricalStats(); err != nil {
+			return err
+		}
+	}
+	return c.checkAppender(true)
+}
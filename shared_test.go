@@ -0,0 +1,106 @@
+package nullitics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSharedStorage(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	a := New(Dir(dir), Location(time.UTC), Clock(clock.Now), Shard("a"))
+	defer a.Close()
+	b := New(Dir(dir), Location(time.UTC), Clock(clock.Now), Shard("b"))
+	defer b.Close()
+	hit := func(c *Collector, uri, session string) {
+		if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: uri, Session: session}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hit(a, "/", "x")
+	hit(b, "/", "x")
+	hit(b, "/b", "y")
+	for _, name := range []string{"log.a.csv", "log.b.csv"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	// Both shards are read, sessions are counted once
+	daily, _, err := a.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := daily.URIs.Row("/").Last(24); n != 2 {
+		t.Error(daily.URIs.Rows)
+	}
+	if n := daily.Sessions.Row("sessions").Last(24); n != 2 {
+		t.Error(daily.Sessions.Rows)
+	}
+	// The first hit of the next day rolls all the shards into the history
+	clock.Add(24 * time.Hour)
+	hit(a, "/a", "z")
+	stats, err := ParseStatsCSV(readFile(t, filepath.Join(dir, historyLog)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats.URIs.Rows, []Row{{"/", []int{2}}, {"/b", []int{1}}}) {
+		t.Error(stats.URIs.Rows)
+	}
+	// Old shard of the other process is not counted twice
+	for _, c := range []*Collector{a, b} {
+		daily, history, err := c.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(history.URIs.Rows, []Row{{"/", []int{2, 0}}, {"/a", []int{0, 1}}, {"/b", []int{1, 0}}}) {
+			t.Error(history.URIs.Rows)
+		}
+		if !reflect.DeepEqual(daily.URIs.Rows, []Row{{"/a", daily.URIs.Row("/a").Values}}) {
+			t.Error(daily.URIs.Rows)
+		}
+	}
+	// The other process truncates its shard without merging it again
+	hit(b, "/b", "y")
+	if _, history, _ := a.Stats(); !reflect.DeepEqual(history.URIs.Row("/b").Values, []int{1, 1}) {
+		t.Error(history.URIs.Rows)
+	}
+	if s := readFile(t, filepath.Join(dir, "log.b.csv")); len(s) == 0 || s[:10] != "1609581600" {
+		t.Error(s)
+	}
+	// Backups include all the shards
+	names, err := a.backupFiles()
+	if err != nil || !reflect.DeepEqual(names, []string{historyLog, dailyLog, "log.a.csv", "log.b.csv"}) {
+		t.Error(names, err)
+	}
+}
+
+func TestSharedSalt(t *testing.T) {
+	dir := t.TempDir()
+	a := New(Dir(dir), Shard("a"))
+	b := New(Dir(dir), Shard("b"))
+	defer a.Close()
+	defer b.Close()
+	ts := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	ka, errA := a.salts.get(ts)
+	kb, errB := b.salts.get(ts)
+	if errA != nil || errB != nil || !reflect.DeepEqual(ka, kb) {
+		t.Error("salts differ")
+	}
+	// Same visitor has the same session in all the processes
+	r := browserRequest("GET", "/")
+	if ha, hb := a.hit(r, false), b.hit(r, false); ha.Session == "" || ha.Session != hb.Session {
+		t.Error(ha, hb)
+	}
+}
+
+func readFile(t *testing.T, filename string) string {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -2,6 +2,7 @@ package nullitics
 
 import (
 	"errors"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -10,17 +11,260 @@ import (
 // Stats is an aggregated data from the various site-related statistics over a
 // given time period.
 type Stats struct {
-	Start     time.Time
-	Interval  time.Duration
+	// Start is the time of the first column with the Interval resolution. It
+	// may be preceded by the coarser columns, one for each of the Periods.
+	Start    time.Time
+	Interval time.Duration
+	// Periods are the start times of the leading downsampled columns, each
+	// column lasts until the start of the next one.
+	Periods   []time.Time
 	URIs      Frame
 	Sessions  Frame
 	Refs      Frame
+	Channels  Frame
 	Countries Frame
 	Devices   Frame
+	Bots      Frame
+	Outbound  Frame
+	Downloads Frame
+	// Goals are the numbers of converted sessions for each goal. Goal breakdown
+	// frames have rows named as "<goal>|<value>".
+	Goals         Frame
+	GoalRefs      Frame
+	GoalCountries Frame
+	GoalDevices   Frame
+	// Funnels are the numbers of sessions reached each funnel step, rows are
+	// named as "<funnel>|<step number>|<step>".
+	Funnels Frame
 }
 
+// frames returns all stats frames in the order they are stored in CSV. New
+// frames must be appended to the end to keep the older CSV files readable.
 func (stats *Stats) frames() []*Frame {
-	return []*Frame{&stats.URIs, &stats.Sessions, &stats.Refs, &stats.Countries, &stats.Devices}
+	return []*Frame{&stats.URIs, &stats.Sessions, &stats.Refs, &stats.Countries, &stats.Devices, &stats.Bots, &stats.Channels,
+		&stats.Outbound, &stats.Downloads, &stats.Goals, &stats.GoalRefs, &stats.GoalCountries, &stats.GoalDevices,
+		&stats.Funnels}
+}
+
+// frameNames are the names of the stats frames in the frames() order.
+var frameNames = []string{"URIs", "Sessions", "Refs", "Countries", "Devices", "Bots", "Channels",
+	"Outbound", "Downloads", "Goals", "GoalRefs", "GoalCountries", "GoalDevices", "Funnels"}
+
+// Frame returns the stats frame by its field name, i.e. "URIs", or nil if
+// there is no such frame.
+func (stats *Stats) Frame(name string) *Frame {
+	for i, frame := range stats.frames() {
+		if frameNames[i] == name {
+			return frame
+		}
+	}
+	return nil
+}
+
+// Slice returns the daily stats for the given time range, including the
+// downsampled periods that overlap with it. Rows with no values within the
+// range are omitted.
+func (stats *Stats) Slice(from, to time.Time) *Stats {
+	s := &Stats{Start: stats.Start, Interval: stats.Interval}
+	cols := []int{}
+	for i, p := range stats.Periods {
+		end := stats.Start
+		if i+1 < len(stats.Periods) {
+			end = stats.Periods[i+1]
+		}
+		if p.Before(to) && end.After(from) {
+			cols = append(cols, i)
+			s.Periods = append(s.Periods, p)
+		}
+	}
+	if s.Start.IsZero() || from.After(s.Start) {
+		s.Start = from
+	}
+	for t := s.Start; t.Before(to); t = t.AddDate(0, 0, 1) {
+		if stats.Start.IsZero() {
+			cols = append(cols, -1)
+		} else {
+			cols = append(cols, len(stats.Periods)+days(stats.Start, t))
+		}
+	}
+	dst := s.frames()
+	for i, frame := range stats.frames() {
+		dst[i].Grow(len(cols))
+		for _, row := range frame.Rows {
+			values, total := make([]int, len(cols)), 0
+			for j, col := range cols {
+				values[j] = row.Get(col)
+				total += values[j]
+			}
+			if total != 0 {
+				dst[i].Rows = append(dst[i].Rows, Row{Name: row.Name, Values: values})
+			}
+		}
+	}
+	return s
+}
+
+// Top keeps at most n rows with the highest totals in each dimension frame,
+// the rest are folded into the Other rows. Sessions, bots, channels, goals
+// and funnels are kept as is.
+func (stats *Stats) Top(n int) {
+	for i, frame := range stats.frames() {
+		name := frameNames[i]
+		switch name {
+		case "Sessions", "Bots", "Channels", "Goals", "Funnels":
+			continue
+		}
+		frame.fold(n, func(row string) string { return otherRow(name, row) })
+	}
+}
+
+// Merge adds the other stats to these ones, row by row. Stats are aligned by
+// their start time and the result covers both time ranges. Stats with
+// different downsampled periods, i.e. the ones rolled over on different days,
+// are rolled into the common periods first. Stats with different intervals
+// can not be merged.
+func (stats *Stats) Merge(other *Stats) error {
+	if other.Start.IsZero() {
+		return nil
+	}
+	empty := stats.Start.IsZero()
+	if !empty && stats.Interval != other.Interval {
+		return errors.New("stats intervals do not match")
+	}
+	if !empty && !stats.samePeriods(other) {
+		periods, start := commonPeriods(stats, other)
+		*stats = *stats.align(periods, start)
+		other = other.align(periods, start)
+	}
+	merged := &Stats{Start: other.Start, Interval: other.Interval, Periods: append([]time.Time{}, other.Periods...)}
+	if !empty && stats.Start.Before(other.Start) {
+		merged.Start = stats.Start
+	}
+	p := len(merged.Periods)
+	sources := []*Stats{other}
+	if !empty {
+		sources = append(sources, stats)
+	}
+	n := 0
+	for _, s := range sources {
+		if w := s.width() + steps(merged.Start, s.Start, merged.Interval); w > n {
+			n = w
+		}
+	}
+	dst := merged.frames()
+	for _, s := range sources {
+		offset := steps(merged.Start, s.Start, merged.Interval)
+		for i, frame := range s.frames() {
+			dst[i].Grow(n)
+			for _, row := range frame.Rows {
+				values := dst[i].Row(row.Name).Values
+				for j, v := range row.Values {
+					if j >= p {
+						j = j + offset
+					}
+					values[j] += v
+				}
+			}
+		}
+	}
+	*stats = *merged
+	return nil
+}
+
+// samePeriods returns true if both stats have no downsampled periods, or have
+// the same periods and start time.
+func (stats *Stats) samePeriods(other *Stats) bool {
+	if len(stats.Periods) == 0 && len(other.Periods) == 0 {
+		return true
+	} else if !stats.Start.Equal(other.Start) || len(stats.Periods) != len(other.Periods) {
+		return false
+	}
+	for i, p := range stats.Periods {
+		if !p.Equal(other.Periods[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// inPeriod returns true if the time is within one of the downsampled periods,
+// but not at its start.
+func (stats *Stats) inPeriod(t time.Time) bool {
+	for i, p := range stats.Periods {
+		end := stats.Start
+		if i+1 < len(stats.Periods) {
+			end = stats.Periods[i+1]
+		}
+		if p.Before(t) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPeriods returns the periods and the start time both stats can be
+// rolled into: daily columns start at the later start time, and the periods
+// before it begin at the period or column boundaries of either stats, unless
+// they are within a period of the other one.
+func commonPeriods(a, b *Stats) ([]time.Time, time.Time) {
+	start := a.Start
+	if b.Start.After(start) {
+		start = b.Start
+	}
+	bounds := append([]time.Time{a.First(), b.First()}, a.Periods...)
+	bounds = append(bounds, b.Periods...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+	periods := []time.Time{}
+	for _, t := range bounds {
+		if !t.Before(start) || (len(periods) > 0 && periods[len(periods)-1].Equal(t)) {
+			continue
+		} else if a.inPeriod(t) || b.inPeriod(t) {
+			continue
+		}
+		periods = append(periods, t)
+	}
+	return periods, start
+}
+
+// align returns a copy of the stats with the columns before the start time
+// rolled into the given periods. Each column must be within a single period.
+func (stats *Stats) align(periods []time.Time, start time.Time) *Stats {
+	s := &Stats{Start: start, Interval: stats.Interval, Periods: periods}
+	n := stats.width()
+	cols, width := make([]int, n), len(periods)
+	for j := range cols {
+		t := stats.Start.AddDate(0, 0, j-len(stats.Periods))
+		if j < len(stats.Periods) {
+			t = stats.Periods[j]
+		}
+		if t.Before(start) {
+			cols[j] = sort.Search(len(periods), func(i int) bool { return periods[i].After(t) }) - 1
+		} else {
+			cols[j] = len(periods) + days(start, t)
+		}
+		if cols[j] >= width {
+			width = cols[j] + 1
+		}
+	}
+	dst := s.frames()
+	for i, frame := range stats.frames() {
+		dst[i].Grow(width)
+		for _, row := range frame.Rows {
+			values := dst[i].Row(row.Name).Values
+			for j, v := range row.Values {
+				values[cols[j]] += v
+			}
+		}
+	}
+	return s
+}
+
+// steps returns the number of intervals between the given times.
+func steps(from, to time.Time, interval time.Duration) int {
+	if interval == 0 || interval >= 24*time.Hour {
+		return days(from, to)
+	}
+	return int(to.Sub(from) / interval)
 }
 
 // CSV returns a CSV-formatted text stats representation.
@@ -31,6 +275,10 @@ func (stats *Stats) CSV() string {
 	b.WriteString(stats.Start.Format(time.RFC3339))
 	b.WriteByte(',')
 	b.WriteString(stats.Interval.String())
+	for _, t := range stats.Periods {
+		b.WriteByte(',')
+		b.WriteString(t.Format(time.RFC3339))
+	}
 	b.WriteByte('\n')
 	// Frames
 	for _, frame := range stats.frames() {
@@ -57,7 +305,7 @@ func ParseStatsCSV(s string) (*Stats, error) {
 		return nil, errors.New("first line must be a comment")
 	}
 	parts := strings.Split(lines[0][1:], ",")
-	if len(parts) != 2 {
+	if len(parts) < 2 {
 		return nil, errors.New("first line must contain a timestamp and interval")
 	}
 	t, err := time.Parse(time.RFC3339, parts[0])
@@ -69,6 +317,13 @@ func ParseStatsCSV(s string) (*Stats, error) {
 		return nil, err
 	}
 	stats := &Stats{Start: t, Interval: d}
+	for _, p := range parts[2:] {
+		t, err := time.Parse(time.RFC3339, p)
+		if err != nil {
+			return nil, err
+		}
+		stats.Periods = append(stats.Periods, t)
+	}
 	lines = lines[1:]
 	n := 0
 	for _, frame := range stats.frames() {
@@ -1,7 +1,9 @@
 package nullitics
 
 import (
+	"io/ioutil"
 	"math/rand"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -87,7 +89,7 @@ func TestFrameGrow(t *testing.T) {
 }
 
 func TestStats(t *testing.T) {
-	stats := &Stats{Start: Now().Round(time.Second), Interval: 3 * time.Hour}
+	stats := &Stats{Start: time.Now().Round(time.Second), Interval: 3 * time.Hour}
 	for _, f := range stats.frames() {
 		f.Grow(30)
 		// Ensure that at least one row would be present in each frame, otherwise
@@ -113,3 +115,187 @@ func TestStats(t *testing.T) {
 		}
 	}
 }
+
+func TestStatsSlice(t *testing.T) {
+	start := time.Date(2021, 1, 18, 0, 0, 0, 0, time.UTC)
+	stats := &Stats{Start: start, Interval: 24 * time.Hour, Periods: []time.Time{start.AddDate(0, 0, -14), start.AddDate(0, 0, -7)}}
+	stats.URIs.Grow(7)
+	copy(stats.URIs.Row("/a").Values, []int{10, 20, 1, 2, 3, 4, 5})
+	copy(stats.URIs.Row("/b").Values, []int{1, 0, 0, 0, 0, 0, 1})
+	for _, test := range []struct {
+		from, to time.Time
+		start    time.Time
+		periods  int
+		a        []int
+		b        bool
+	}{
+		// Daily columns only, beyond the last column there are zeros
+		{start.AddDate(0, 0, 1), start.AddDate(0, 0, 7), start.AddDate(0, 0, 1), 0, []int{2, 3, 4, 5, 0, 0}, true},
+		// Overlapping periods are included as a whole
+		{start.AddDate(0, 0, -3), start.AddDate(0, 0, 2), start, 1, []int{20, 1, 2}, false},
+		{start.AddDate(0, 0, -30), start.AddDate(0, 0, 1), start, 2, []int{10, 20, 1}, true},
+	} {
+		s := stats.Slice(test.from, test.to)
+		if !s.Start.Equal(test.start) || len(s.Periods) != test.periods || !reflect.DeepEqual(s.URIs.Row("/a").Values, test.a) {
+			t.Error(test, s.Start, s.Periods, s.URIs.Rows)
+		}
+		if _, found := s.URIs.find("/b"); found != test.b {
+			t.Error(test, s.URIs.Rows)
+		}
+	}
+}
+
+func TestStatsTop(t *testing.T) {
+	stats := &Stats{}
+	for _, f := range []*Frame{&stats.URIs, &stats.Goals, &stats.GoalRefs} {
+		f.Grow(1)
+		for i := 0; i < 5; i++ {
+			f.Row(string(rune('a'+i)) + "|x").Values[0] = i + 1
+		}
+	}
+	stats.Top(2)
+	if len(stats.URIs.Rows) != 3 || stats.URIs.Row(Other).Values[0] != 6 {
+		t.Error(stats.URIs.Rows)
+	}
+	if len(stats.GoalRefs.Rows) != 5 || stats.GoalRefs.Row("a|"+Other).Values[0] != 1 {
+		t.Error(stats.GoalRefs.Rows)
+	}
+	if len(stats.Goals.Rows) != 5 {
+		t.Error(stats.Goals.Rows)
+	}
+}
+
+func TestStatsMerge(t *testing.T) {
+	a := &Stats{Start: day(2021, 1, 1), Interval: 24 * time.Hour}
+	a.URIs.Grow(3)
+	copy(a.URIs.Row("/").Values, []int{1, 2, 3})
+	copy(a.URIs.Row("/a").Values, []int{1, 1, 1})
+	b := &Stats{Start: day(2021, 1, 3), Interval: 24 * time.Hour}
+	b.URIs.Grow(2)
+	copy(b.URIs.Row("/").Values, []int{10, 20})
+	copy(b.URIs.Row("/b").Values, []int{5, 0})
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Start.Equal(day(2021, 1, 1)) || !reflect.DeepEqual(a.URIs.Rows, []Row{
+		{"/", []int{1, 2, 13, 20}},
+		{"/a", []int{1, 1, 1, 0}},
+		{"/b", []int{0, 0, 5, 0}},
+	}) {
+		t.Error(a.Start, a.URIs.Rows)
+	}
+	// Merging into empty stats makes a copy
+	c := &Stats{}
+	if err := c.Merge(b); err != nil || !c.Start.Equal(b.Start) || !reflect.DeepEqual(c.URIs.Rows, b.URIs.Rows) {
+		t.Error(c.URIs.Rows, err)
+	}
+	c.URIs.Row("/").Values[0] = 0
+	if b.URIs.Row("/").Values[0] != 10 {
+		t.Error(b.URIs.Rows)
+	}
+	// Mismatching stats
+	if err := a.Merge(&Stats{Start: day(2021, 1, 1), Interval: time.Hour}); err == nil {
+		t.Error("intervals do not match")
+	}
+}
+
+func TestStatsMergePeriods(t *testing.T) {
+	// Replica that rolled January into a monthly period already
+	a := &Stats{Start: day(2021, 2, 1), Interval: 24 * time.Hour, Periods: []time.Time{day(2020, 12, 1), day(2021, 1, 1)}}
+	a.URIs.Grow(4)
+	copy(a.URIs.Row("/").Values, []int{100, 31, 1, 2})
+	// Replica that did not, and has started later
+	b := &Stats{Start: day(2021, 1, 30), Interval: 24 * time.Hour, Periods: []time.Time{day(2021, 1, 1)}}
+	b.URIs.Grow(5)
+	copy(b.URIs.Row("/").Values, []int{29, 1, 1, 10, 20})
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Start.Equal(day(2021, 2, 1)) || !reflect.DeepEqual(a.Periods, []time.Time{day(2020, 12, 1), day(2021, 1, 1)}) ||
+		!reflect.DeepEqual(a.URIs.Rows, []Row{{"/", []int{100, 62, 11, 22}}}) {
+		t.Error(a.Start, a.Periods, a.URIs.Rows)
+	}
+	// Other stats are not modified
+	if !b.Start.Equal(day(2021, 1, 30)) || !reflect.DeepEqual(b.URIs.Row("/").Values, []int{29, 1, 1, 10, 20}) {
+		t.Error(b.Start, b.URIs.Rows)
+	}
+}
+
+func TestCollectorReplicas(t *testing.T) {
+	clock := NewFakeClock(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for i, dir := range dirs {
+		r := New(Dir(dir), Location(time.UTC), Clock(clock.Now))
+		for j := 0; j <= i; j++ {
+			if err := r.Hit(&Hit{Timestamp: clock.Now().AddDate(0, 0, j), URI: "/", Session: "a"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.Close()
+	}
+	c := New(Dir(t.TempDir()), Location(time.UTC), Clock(clock.Now), Replicas(dirs...))
+	defer c.Close()
+	if err := c.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	daily, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history.URIs.Row("/").Values, []int{3, 1}) {
+		t.Error(history.URIs.Rows)
+	}
+	// Replica logs of the other days are not in the daily stats
+	if n := daily.URIs.Row("/").Last(24); n != 2 {
+		t.Error(daily.URIs.Rows)
+	}
+	// Own history is not modified
+	if _, history, _ := c.Stats(); history.URIs.Row("/").Last(10) != 4 {
+		t.Error(history.URIs.Rows)
+	}
+	if !reflect.DeepEqual(c.history.URIs.Row("/").Values, []int{1}) {
+		t.Error(c.history.URIs.Rows)
+	}
+	// Replica stats are re-read once its data changes
+	r := New(Dir(dirs[0]), Location(time.UTC), Clock(clock.Now))
+	if err := r.Hit(&Hit{Timestamp: clock.Now(), URI: "/", Session: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if daily, _, _ := c.Stats(); daily.URIs.Row("/").Last(24) != 3 {
+		t.Error(daily.URIs.Rows)
+	}
+}
+
+func TestReplicasOption(t *testing.T) {
+	c := New(Dir(t.TempDir()), Replicas("a"), Replicas("b", "c"))
+	defer c.Close()
+	if !reflect.DeepEqual(c.replicas, []string{"a", "b", "c"}) {
+		t.Error(c.replicas)
+	}
+}
+
+func TestCollectorReplicasPeriods(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	a := &Stats{Start: day(2021, 2, 1), Interval: 24 * time.Hour, Periods: []time.Time{day(2021, 1, 1)}}
+	a.URIs.Grow(2)
+	copy(a.URIs.Row("/").Values, []int{31, 1})
+	b := &Stats{Start: day(2021, 1, 31), Interval: 24 * time.Hour, Periods: []time.Time{day(2021, 1, 1)}}
+	b.URIs.Grow(3)
+	copy(b.URIs.Row("/").Values, []int{30, 1, 10})
+	for i, stats := range []*Stats{a, b} {
+		if err := ioutil.WriteFile(filepath.Join(dirs[i], historyLog), []byte(stats.CSV()), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock := NewFakeClock(time.Date(2021, 2, 2, 10, 0, 0, 0, time.UTC))
+	c := New(Dir(dirs[0]), Location(time.UTC), Clock(clock.Now), Replicas(dirs[1]))
+	defer c.Close()
+	_, history, err := c.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(history.URIs.Row("/").Values, []int{62, 11}) {
+		t.Error(history.Periods, history.URIs.Rows)
+	}
+}